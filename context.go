@@ -0,0 +1,321 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderTimeoutExtended is set on the response when the handler extended
+// its deadline with Extend, the value is the total extension in milliseconds.
+const HeaderTimeoutExtended = "X-Timeout-Extended"
+
+// The headers set by WithTimingHeaders, the values are in milliseconds.
+const (
+	// HeaderTimeoutBudget is the timeout, including extensions
+	HeaderTimeoutBudget = "X-Timeout-Budget"
+	// HeaderTimeoutRemaining is the time left before the deadline
+	// when the response was written, 0 for the timeout response
+	HeaderTimeoutRemaining = "X-Timeout-Remaining"
+)
+
+// ErrHandlerTimeout is the cancellation cause of the request context
+// when the timeout configured by Timeout expires.
+// It wraps http.ErrHandlerTimeout, so both can be matched with errors.Is.
+var ErrHandlerTimeout = fmt.Errorf("gin-timeout: %w", http.ErrHandlerTimeout)
+
+// ErrExtendDenied is returned by Extend when the deadline cannot be pushed out.
+var ErrExtendDenied = errors.New("gin-timeout: deadline extension denied")
+
+type stateKey struct{}
+
+// requestState is the per-request information Timeout stores
+// in the request context.
+type requestState struct {
+	// id is unique in the process, see requestSeq
+	id      uint64
+	start   time.Time
+	timeout time.Duration
+	// see WithRequestIDHeader
+	requestIDHeader string
+	// set the timing headers, see WithTimingHeaders
+	timing bool
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+
+	// extension policy, see WithExtendLimit
+	maxExtension   time.Duration
+	maxExtendCount int
+
+	extensions int
+	extended   time.Duration
+
+	// the stack captured at the slow threshold, see watchSlow
+	slowStack string
+}
+
+func newRequestState(opts *TimeoutOptions) *requestState {
+	now := time.Now()
+	return &requestState{
+		id:              requestSeq.Add(1),
+		start:           now,
+		timeout:         opts.Timeout,
+		requestIDHeader: opts.RequestIDHeader,
+		timing:          opts.TimingHeaders,
+		deadline:        now.Add(opts.Timeout),
+		maxExtension:    opts.MaxExtension,
+		maxExtendCount:  opts.MaxExtendCount,
+	}
+}
+
+// withContext derives the context the handler runs with.
+// Unlike context.WithTimeout, its deadline can be pushed out by extend.
+func (st *requestState) withContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	dc := &deadlineCtx{Context: ctx, cancelCause: cancel, st: st, done: make(chan struct{})}
+	st.timer = time.AfterFunc(time.Until(st.deadline), func() {
+		dc.cancel(context.DeadlineExceeded, ErrHandlerTimeout)
+	})
+	stop := context.AfterFunc(parent, func() {
+		dc.cancel(parent.Err(), context.Cause(parent))
+	})
+	return dc, func() {
+		st.timer.Stop()
+		stop()
+		dc.cancel(context.Canceled, context.Canceled)
+	}
+}
+
+func (st *requestState) getDeadline() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.deadline
+}
+
+func (st *requestState) getExtended() time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.extended
+}
+
+func (st *requestState) extend(d time.Duration) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.extensions >= st.maxExtendCount {
+		return fmt.Errorf("%w: at most %d extensions allowed", ErrExtendDenied, st.maxExtendCount)
+	}
+	if d > st.maxExtension-st.extended {
+		d = st.maxExtension - st.extended
+	}
+	if d <= 0 {
+		return fmt.Errorf("%w: at most %v extension allowed", ErrExtendDenied, st.maxExtension)
+	}
+	// the timer has already fired, it is too late
+	if !st.timer.Stop() {
+		return ErrHandlerTimeout
+	}
+
+	st.extensions++
+	st.extended += d
+	st.deadline = st.deadline.Add(d)
+	st.timer.Reset(time.Until(st.deadline))
+	return nil
+}
+
+// setHeaders sets the headers describing the deadline on the response,
+// timedOut is true for the timeout response.
+func (st *requestState) setHeaders(h http.Header, timedOut bool) {
+	now := time.Now()
+	st.mu.Lock()
+	extended, deadline := st.extended, st.deadline
+	st.mu.Unlock()
+
+	if extended > 0 {
+		h.Set(HeaderTimeoutExtended, strconv.FormatInt(extended.Milliseconds(), 10))
+	}
+	if !st.timing {
+		return
+	}
+	budget := st.timeout + extended
+	remaining := max(deadline.Sub(now), 0)
+	if timedOut {
+		remaining = 0
+	}
+	h.Set(HeaderTimeoutBudget, strconv.FormatInt(budget.Milliseconds(), 10))
+	h.Set(HeaderTimeoutRemaining, strconv.FormatInt(remaining.Milliseconds(), 10))
+
+	// Add, so that the metrics of the handlers are kept
+	elapsed := fmt.Sprintf("timeout-elapsed;dur=%.3f", float64(now.Sub(st.start))/float64(time.Millisecond))
+	if timedOut {
+		elapsed += `;desc="timed out"`
+	}
+	h.Add("Server-Timing", budgetTiming(budget)+", "+elapsed)
+}
+
+// setBudgetHeaders sets the headers known before the handlers run, for
+// inline mode, where they commit the response. It returns the Server-Timing
+// entry it added, "" if none.
+func (st *requestState) setBudgetHeaders(h http.Header) string {
+	if !st.timing {
+		return ""
+	}
+	h.Set(HeaderTimeoutBudget, strconv.FormatInt(st.timeout.Milliseconds(), 10))
+	entry := budgetTiming(st.timeout)
+	h.Add("Server-Timing", entry)
+	return entry
+}
+
+func budgetTiming(budget time.Duration) string {
+	return fmt.Sprintf("timeout-budget;dur=%d", budget.Milliseconds())
+}
+
+func stateFrom(ctx context.Context) (*requestState, bool) {
+	st, ok := ctx.Value(stateKey{}).(*requestState)
+	return st, ok
+}
+
+// deadlineCtx reports the deadline of a requestState, which may move,
+// and context.DeadlineExceeded once it expires, like context.WithDeadline.
+//
+// The embedded context only carries the cause, see context.Cause, its Err
+// is context.Canceled. Done is a channel of its own, closed by cancel on
+// the goroutine cancelling the context, before the derived contexts are
+// cancelled, see AfterFunc. So the middleware, waiting on Done, always
+// sees the timeout before the handlers waiting on a derived context.
+type deadlineCtx struct {
+	context.Context
+	cancelCause context.CancelCauseFunc
+	st          *requestState
+
+	mu   sync.Mutex
+	done chan struct{}
+	err  error
+	// the derived contexts, cancelled by cancel
+	afterFuncs map[*func()]struct{}
+}
+
+// cancel cancels c, unless it already is, with err as its Err and cause
+// as its Cause.
+func (c *deadlineCtx) cancel(err, cause error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.cancelCause(cause)
+	c.err = err
+	close(c.done)
+	afterFuncs := c.afterFuncs
+	c.afterFuncs = nil
+	c.mu.Unlock()
+
+	for f := range afterFuncs {
+		(*f)()
+	}
+}
+
+func (c *deadlineCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *deadlineCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// AfterFunc is used by the context package to cancel the derived contexts,
+// f is called once Done is closed, without starting a goroutine.
+func (c *deadlineCtx) AfterFunc(f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		go f()
+		return func() bool { return false }
+	}
+	if c.afterFuncs == nil {
+		c.afterFuncs = make(map[*func()]struct{})
+	}
+	key := &f
+	c.afterFuncs[key] = struct{}{}
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		_, ok := c.afterFuncs[key]
+		delete(c.afterFuncs, key)
+		return ok
+	}
+}
+
+func (c *deadlineCtx) Deadline() (time.Time, bool) {
+	deadline := c.st.getDeadline()
+	if parent, ok := c.Context.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
+}
+
+func (c *deadlineCtx) Value(key any) any {
+	if key == (stateKey{}) {
+		return c.st
+	}
+	return c.Context.Value(key)
+}
+
+// IsTimedOut reports whether ctx, or one of its parents,
+// was cancelled because the timeout configured by Timeout expired.
+func IsTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrHandlerTimeout)
+}
+
+// Deadline returns the deadline Timeout set for the request.
+// ok is false if the request is not handled by Timeout.
+func Deadline(c *gin.Context) (deadline time.Time, ok bool) {
+	st, ok := stateFrom(c.Request.Context())
+	if !ok {
+		return time.Time{}, false
+	}
+	return st.getDeadline(), true
+}
+
+// Remaining returns the time left before the deadline Timeout set for the
+// request, or 0 if it has already passed.
+// ok is false if the request is not handled by Timeout.
+func Remaining(c *gin.Context) (d time.Duration, ok bool) {
+	deadline, ok := Deadline(c)
+	if !ok {
+		return 0, false
+	}
+	if d = time.Until(deadline); d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// Extend pushes the deadline of the request out by d.
+// The extension is capped by the limits configured with WithExtendLimit,
+// ErrExtendDenied is returned once they are used up.
+// If the timeout has already expired, ErrHandlerTimeout is returned.
+func Extend(c *gin.Context, d time.Duration) error {
+	st, ok := stateFrom(c.Request.Context())
+	if !ok {
+		return fmt.Errorf("%w: request is not handled by Timeout", ErrExtendDenied)
+	}
+	if tw, ok := c.Writer.(*TimeoutWriter); ok {
+		tw.mu.Lock()
+		if tw.timedOut.Load() {
+			tw.relabel()
+		}
+		tw.mu.Unlock()
+	}
+	return st.extend(d)
+}
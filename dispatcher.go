@@ -0,0 +1,133 @@
+package timeout
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what a Dispatcher does when its queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the callback being dispatched.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest queued callback to make room.
+	// The queue holds at least one callback.
+	DropOldest
+	// Block waits until there is room in the queue.
+	Block
+)
+
+// Dispatcher runs callbacks on a fixed number of worker goroutines,
+// so that slow callbacks do not delay the responses.
+// See WithDispatcher.
+type Dispatcher struct {
+	queue   chan func()
+	policy  DropPolicy
+	dropped atomic.Uint64
+	panics  atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher starts workers goroutines consuming a queue of queueSize callbacks.
+func NewDispatcher(workers, queueSize int, policy DropPolicy) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	// with no room to make, DropOldest would spin
+	if policy == DropOldest && queueSize < 1 {
+		queueSize = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	d := &Dispatcher{
+		queue:  make(chan func(), queueSize),
+		policy: policy,
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for f := range d.queue {
+		d.run(f)
+	}
+}
+
+func (d *Dispatcher) run(f func()) {
+	// a panicking callback must not take the worker down with it
+	defer func() {
+		if p := recover(); p != nil {
+			d.panics.Add(1)
+		}
+	}()
+	f()
+}
+
+// Dispatch queues f according to the DropPolicy.
+// It reports whether f was queued, and counts it as dropped otherwise.
+func (d *Dispatcher) Dispatch(f func()) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.dropped.Add(1)
+		return false
+	}
+
+	switch d.policy {
+	case Block:
+		d.queue <- f
+		return true
+	case DropOldest:
+		for {
+			select {
+			case d.queue <- f:
+				return true
+			default:
+			}
+			select {
+			case <-d.queue:
+				d.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case d.queue <- f:
+			return true
+		default:
+			d.dropped.Add(1)
+			return false
+		}
+	}
+}
+
+// Dropped returns the number of callbacks discarded so far.
+func (d *Dispatcher) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// Panics returns the number of callbacks that panicked so far,
+// the panics are recovered so that the workers keep running.
+func (d *Dispatcher) Panics() uint64 {
+	return d.panics.Load()
+}
+
+// Close stops accepting callbacks and waits for the queued ones to run.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
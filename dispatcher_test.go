@@ -0,0 +1,95 @@
+package timeout
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherDropPolicy(t *testing.T) {
+	release := make(chan struct{})
+	block := func() { <-release }
+
+	var ran atomic.Int32
+	count := func() { ran.Add(1) }
+
+	d := NewDispatcher(1, 1, DropNewest)
+	assert.True(t, d.Dispatch(block))
+	// wait for the worker to pick up block, so the queue is empty
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, d.Dispatch(count))
+	assert.False(t, d.Dispatch(count))
+	assert.Equal(t, uint64(1), d.Dropped())
+	close(release)
+	d.Close()
+	assert.Equal(t, int32(1), ran.Load())
+	assert.False(t, d.Dispatch(count))
+	assert.Equal(t, uint64(2), d.Dropped())
+
+	release = make(chan struct{})
+	ran.Store(0)
+	var last atomic.Int32
+	d = NewDispatcher(1, 1, DropOldest)
+	d.Dispatch(block)
+	time.Sleep(10 * time.Millisecond)
+	d.Dispatch(func() { last.Store(1) })
+	d.Dispatch(func() { last.Store(2) })
+	assert.Equal(t, uint64(1), d.Dropped())
+	close(release)
+	d.Close()
+	assert.Equal(t, int32(2), last.Load())
+
+	// an unbuffered queue has no room to make
+	release = make(chan struct{})
+	d = NewDispatcher(1, 0, DropOldest)
+	d.Dispatch(block)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, d.Dispatch(count))
+	assert.True(t, d.Dispatch(count))
+	assert.Equal(t, uint64(1), d.Dropped())
+	close(release)
+	d.Close()
+}
+
+func TestDispatcherPanic(t *testing.T) {
+	var ran atomic.Bool
+	d := NewDispatcher(1, 2, Block)
+	d.Dispatch(func() { panic("callback") })
+	d.Dispatch(func() { ran.Store(true) })
+	d.Close()
+	assert.True(t, ran.Load())
+	assert.Equal(t, uint64(1), d.Panics())
+	assert.Equal(t, uint64(0), d.Dropped())
+}
+
+func TestWithDispatcher(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan string, 1)
+	d := NewDispatcher(1, 10, DropNewest)
+	defer d.Close()
+
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(50*time.Millisecond),
+		WithDispatcher(d),
+		WithGinCtxCallBack(func(c *gin.Context) {
+			<-release
+			done <- c.Request.URL.Path
+		}),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	start := time.Now()
+	code, _, _ := Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Less(t, time.Since(start), time.Second)
+
+	close(release)
+	assert.Equal(t, "/long", <-done)
+}
@@ -0,0 +1,96 @@
+package timeout
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin/render"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoder returns the gin render encoding v, see WithEncoder.
+type Encoder func(v any) render.Render
+
+// defaultEncoders are used for the content of a Response, by media type.
+// JSON uses the codec gin is built with.
+var defaultEncoders = map[string]Encoder{
+	"application/json":       jsonEncoder,
+	"application/xml":        xmlEncoder,
+	"text/xml":               xmlEncoder,
+	"application/yaml":       yamlEncoder,
+	"application/x-yaml":     yamlEncoder,
+	"application/toml":       tomlEncoder,
+	"application/protobuf":   protobufEncoder,
+	"application/x-protobuf": protobufEncoder,
+}
+
+func jsonEncoder(v any) render.Render { return render.JSON{Data: v} }
+func xmlEncoder(v any) render.Render  { return render.XML{Data: v} }
+func yamlEncoder(v any) render.Render { return render.YAML{Data: v} }
+func tomlEncoder(v any) render.Render { return render.TOML{Data: v} }
+
+func protobufEncoder(v any) render.Render {
+	if _, ok := v.(proto.Message); !ok {
+		return errorRender{fmt.Errorf("%T is not a proto.Message", v)}
+	}
+	return render.ProtoBuf{Data: v}
+}
+
+// errorRender fails to render with err.
+type errorRender struct {
+	err error
+}
+
+func (r errorRender) Render(http.ResponseWriter) error     { return r.err }
+func (r errorRender) WriteContentType(http.ResponseWriter) {}
+
+// encoderFor returns the encoder of contentType, the structured syntax
+// suffixes +json and +xml are recognized, JSON is the default.
+func encoderFor(contentType string, encoders map[string]Encoder) Encoder {
+	mt := mediaType(contentType)
+	if enc, ok := encoders[mt]; ok {
+		return enc
+	}
+	if enc, ok := defaultEncoders[mt]; ok {
+		return enc
+	}
+	switch {
+	case strings.HasSuffix(mt, "+xml"):
+		return xmlEncoder
+	default:
+		return jsonEncoder
+	}
+}
+
+// encodeBytes encodes the content of a Response, strings and
+// byte slices are used as is.
+func encodeBytes(any interface{}, contentType string, encoders map[string]Encoder) ([]byte, error) {
+	switch demsg := any.(type) {
+	case string:
+		return []byte(demsg), nil
+	case []byte:
+		return demsg, nil
+	}
+	var w bufferWriter
+	if err := encoderFor(contentType, encoders)(any).Render(&w); err != nil {
+		return nil, fmt.Errorf("gin-timeout: encode %T as %s: %w", any, contentType, err)
+	}
+	return w.Bytes(), nil
+}
+
+// bufferWriter collects the body written by a render.Render.
+type bufferWriter struct {
+	bytes.Buffer
+	h http.Header
+}
+
+func (w *bufferWriter) Header() http.Header {
+	if w.h == nil {
+		w.h = make(http.Header)
+	}
+	return w.h
+}
+
+func (w *bufferWriter) WriteHeader(int) {}
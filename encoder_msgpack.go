@@ -0,0 +1,14 @@
+//go:build !nomsgpack
+
+package timeout
+
+import (
+	"github.com/gin-gonic/gin/render"
+)
+
+func init() {
+	defaultEncoders["application/msgpack"] = msgpackEncoder
+	defaultEncoders["application/x-msgpack"] = msgpackEncoder
+}
+
+func msgpackEncoder(v any) render.Render { return render.MsgPack{Data: v} }
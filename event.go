@@ -0,0 +1,89 @@
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeoutEvent is a snapshot of a request handled by Timeout.
+// It does not expose the gin.Context or the http.Request,
+// so it can be retained and used from any goroutine.
+type TimeoutEvent struct {
+	Method string
+	// FullPath is the matched route pattern, e.g. /user/:id
+	FullPath string
+	Path     string
+	Params   gin.Params
+	ClientIP string
+	// Header holds the request headers selected with WithEventHeaders
+	Header http.Header
+	// RequestID is read from the header set with WithRequestIDHeader
+	RequestID string
+
+	Start   time.Time
+	Elapsed time.Duration
+	// Timeout is the configured timeout
+	Timeout time.Duration
+	// Extended is how much the handlers extended the timeout, see Extend
+	Extended time.Duration
+
+	// Status and Size of the response,
+	// they are set from OnFinish and OnTimeout on.
+	Status int
+	Size   int
+	// Buffered is true if the body of the handlers was buffered by the
+	// middleware, false in inline mode, see WithInline.
+	Buffered bool
+	// TimedOut is true once the middleware stopped waiting for the handlers,
+	// because the timeout expired or the client went away.
+	TimedOut bool
+	// Panic is the value the handlers panicked with, see Observer.OnPanic
+	Panic any
+	// Stack of the handler goroutine when the timeout expired,
+	// or the slow threshold was crossed, see WithStackCapture
+	Stack string
+	// SlowThreshold is set for SlowObserver.OnSlow, see WithSlowRatio
+	SlowThreshold time.Duration
+
+	// only for the callbacks adapted to Observer, see callbackObserver
+	req    *http.Request
+	ginCtx *gin.Context
+}
+
+func newTimeoutEvent(c *gin.Context, opts *TimeoutOptions, st *requestState) TimeoutEvent {
+	ev := TimeoutEvent{
+		Method:   c.Request.Method,
+		FullPath: c.FullPath(),
+		Path:     c.Request.URL.Path,
+		Params:   slices.Clone(c.Params),
+		ClientIP: c.ClientIP(),
+		Start:    st.start,
+		Timeout:  opts.Timeout,
+		Buffered: !opts.Inline,
+	}
+	if opts.RequestIDHeader != "" {
+		ev.RequestID = c.Request.Header.Get(opts.RequestIDHeader)
+	}
+	if len(opts.EventHeaders) > 0 {
+		ev.Header = make(http.Header, len(opts.EventHeaders))
+		for _, key := range opts.EventHeaders {
+			if vv := c.Request.Header.Values(key); len(vv) > 0 {
+				ev.Header[http.CanonicalHeaderKey(key)] = slices.Clone(vv)
+			}
+		}
+	}
+	return ev
+}
+
+// Context returns the context the handlers run with, it is cancelled
+// once the timeout expires. Observers can use it to find the active span.
+func (ev TimeoutEvent) Context() context.Context {
+	if ev.req == nil {
+		return context.Background()
+	}
+	return ev.req.Context()
+}
@@ -33,7 +33,8 @@ type Response struct {
 
 // GetMessage rewrite method for other logic such as response translation
 func (r *Response) GetContent(c *gin.Context) any {
-	// You can translate the response message based on the request header
+	// You can translate the response message based on the request header,
+	// timeout.I18nResponse does it for you, see example/i18n_response
 	log.Println("Accept-Language: ", c.Request.Header.Get("Accept-Language"))
 	return r.Content
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"embed"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	timeout "github.com/vearne/gin-timeout"
+)
+
+// test usage:
+//  curl -H 'Accept-Language: zh-CN,zh;q=0.9,en;q=0.8' -i http://localhost:8080/long
+//  curl -H 'Accept-Language: ja' -i http://localhost:8080/long
+
+//go:embed locales/*.json
+var locales embed.FS
+
+func main() {
+	catalog, err := timeout.LoadCatalog(locales, "locales/*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// create new gin without any middleware
+	engine := gin.Default()
+
+	// add timeout middleware with 2 second duration
+	engine.Use(timeout.Timeout(
+		timeout.WithTimeout(2*time.Second),
+		timeout.WithResponse(&timeout.I18nResponse{
+			Code:      http.StatusServiceUnavailable,
+			Catalog:   catalog,
+			MessageID: "timeout",
+			Fallbacks: []string{"en"},
+		}),
+	))
+
+	// create a handler that will last 5 seconds but can be canceled.
+	engine.GET("/long", func(c *gin.Context) {
+		select {
+		case <-time.After(5 * time.Second):
+			c.String(http.StatusOK, "long")
+		case <-c.Request.Context().Done():
+		}
+	})
+
+	// run the server
+	log.Fatal(engine.Run(":8080"))
+}
@@ -0,0 +1,232 @@
+package timeout
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Catalog holds translated messages by locale and message id.
+// The messages are text/template templates, see I18nResponse
+// for the data they are executed with.
+type Catalog struct {
+	mu       sync.RWMutex
+	messages map[string]map[string]*template.Template
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{messages: make(map[string]map[string]*template.Template)}
+}
+
+// LoadCatalog loads the JSON files of fsys matching pattern, e.g.
+// "locales/*.json". Each file holds the messages of the locale named
+// after it, e.g. locales/pt-BR.json:
+//
+//	{"timeout": "A requisição excedeu o tempo limite de {{.Timeout}}"}
+func LoadCatalog(fsys fs.FS, pattern string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCatalog()
+	for _, file := range files {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("gin-timeout: load catalog %s: %w", file, err)
+		}
+		locale := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		if err := c.Add(locale, messages); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Add adds messages, by message id, to locale, e.g. en or zh-Hant-TW.
+func (c *Catalog) Add(locale string, messages map[string]string) error {
+	locale = normalizeLocale(locale)
+	tmpls := make(map[string]*template.Template, len(messages))
+	for id, msg := range messages {
+		t, err := template.New(id).Parse(msg)
+		if err != nil {
+			return fmt.Errorf("gin-timeout: message %s of %s: %w", id, locale, err)
+		}
+		tmpls[id] = t
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = tmpls
+		return nil
+	}
+	for id, t := range tmpls {
+		c.messages[locale][id] = t
+	}
+	return nil
+}
+
+func (c *Catalog) lookup(locale, id string) *template.Template {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.messages[locale][id]
+}
+
+// negotiate returns the locale to translate id to, given an Accept-Language
+// header. Each accepted language falls back to its prefixes, e.g. zh-hant-tw
+// to zh-hant then zh, before the next one is tried, then fallbacks are.
+// A nil Catalog has no translation.
+func (c *Catalog) negotiate(acceptLanguage, id string, fallbacks []string) (string, *template.Template) {
+	if c == nil {
+		return "", nil
+	}
+	accept := parseAccept(acceptLanguage)
+	tags := make([]string, 0, len(accept)+len(fallbacks))
+	for _, item := range accept {
+		// * matches any locale, the fallbacks are as good as any
+		if item.q == 0 || item.value == "*" {
+			break
+		}
+		tags = append(tags, normalizeLocale(item.value))
+	}
+	for _, fallback := range fallbacks {
+		tags = append(tags, normalizeLocale(fallback))
+	}
+
+	for _, tag := range tags {
+		for {
+			if rejected(accept, tag) {
+				break
+			}
+			if t := c.lookup(tag, id); t != nil {
+				return tag, t
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return "", nil
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// I18nResponse translates the message MessageID of Catalog to the language
+// negotiated from the Accept-Language header of the request, trying
+// Fallbacks last, and sets Content-Language and Vary: Accept-Language.
+// The message is executed with the following data, plus Params:
+//
+//	.Timeout    the timeout, including the extensions, e.g. 3s
+//	.Method     the request method
+//	.Path       the request path
+//	.RequestID  the request id, see WithRequestIDHeader
+//
+// The body is {"code": -1, "msg": <message>} if ContentType is JSON,
+// the message otherwise, HTML escaped if ContentType is HTML: the messages
+// are plain text, and .Path comes from the client.
+// MessageID is used if there is no translation, e.g. Catalog is nil.
+type I18nResponse struct {
+	Code        int
+	ContentType string
+	Catalog     *Catalog
+	MessageID   string
+	Fallbacks   []string
+	Params      map[string]any
+}
+
+// i18nKey is the key of the translation in the gin.Context,
+// GetContent and GetHeaders both need it.
+const i18nKey = "github.com/vearne/gin-timeout/i18n"
+
+type translation struct {
+	locale, msg string
+}
+
+// translate returns the negotiated locale and the translated message,
+// they are computed once per timeout response.
+func (r *I18nResponse) translate(c *gin.Context) (string, string) {
+	if v, ok := c.Get(i18nKey); ok {
+		if t, ok := v.(translation); ok {
+			return t.locale, t.msg
+		}
+	}
+	locale, msg := r.execute(c)
+	c.Set(i18nKey, translation{locale: locale, msg: msg})
+	return locale, msg
+}
+
+func (r *I18nResponse) execute(c *gin.Context) (string, string) {
+	locale, t := r.Catalog.negotiate(c.GetHeader("Accept-Language"), r.MessageID, r.Fallbacks)
+	if t == nil {
+		return "", r.MessageID
+	}
+
+	data := make(map[string]any, len(r.Params)+4)
+	for k, v := range r.Params {
+		data[k] = v
+	}
+	data["Method"] = c.Request.Method
+	data["Path"] = c.Request.URL.Path
+	if st, ok := stateFrom(c.Request.Context()); ok {
+		data["Timeout"] = st.timeout + st.getExtended()
+		if st.requestIDHeader != "" {
+			data["RequestID"] = c.GetHeader(st.requestIDHeader)
+		}
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", r.MessageID
+	}
+	return locale, b.String()
+}
+
+func (r *I18nResponse) GetCode(c *gin.Context) int {
+	if r.Code == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return r.Code
+}
+
+func (r *I18nResponse) GetContent(c *gin.Context) any {
+	_, msg := r.translate(c)
+	switch mt := mediaType(r.GetContentType(c)); {
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		return gin.H{"code": -1, "msg": msg}
+	case mt == "text/html" || mt == "application/xhtml+xml":
+		return html.EscapeString(msg)
+	}
+	return msg
+}
+
+func (r *I18nResponse) GetContentType(c *gin.Context) string {
+	if r.ContentType == "" {
+		return "application/json; charset=utf-8"
+	}
+	return r.ContentType
+}
+
+// GetHeaders implements HeaderResponse.
+func (r *I18nResponse) GetHeaders(c *gin.Context) http.Header {
+	h := http.Header{"Vary": {"Accept-Language"}}
+	if locale, _ := r.translate(c); locale != "" {
+		h.Set("Content-Language", locale)
+	}
+	return h
+}
@@ -0,0 +1,85 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inline runs the handlers on the calling goroutine, see WithInline.
+func inline(c *gin.Context, opts *TimeoutOptions) {
+	st := newRequestState(opts)
+	ctx, cancel := st.withContext(c.Request.Context())
+	defer cancel()
+
+	req := c.Request
+	ev, dreq := opts.newEvent(c, req.WithContext(ctx), st)
+	c.Request = dreq
+	opts.observe(Observer.OnStart, ev)
+	opts.Registry.add(ev, st)
+	defer opts.Registry.remove(st)
+	if opts.observer != nil {
+		defer func() {
+			if p := recover(); p != nil {
+				ev.Panic = p
+				ev.Elapsed = time.Since(st.start)
+				opts.observe(Observer.OnPanic, ev)
+				panic(p)
+			}
+		}()
+	}
+
+	// the handlers may commit the response, the headers are set before
+	budget := st.setBudgetHeaders(c.Writer.Header())
+
+	c.Next()
+	c.Request = req
+
+	ev.Elapsed = time.Since(st.start)
+	ev.Extended = st.getExtended()
+
+	// the handlers already committed a response, it is too late to replace it.
+	if c.Writer.Written() || ctx.Err() == nil {
+		// gin's Size is -1 until the body is written
+		ev.Status, ev.Size = c.Writer.Status(), max(c.Writer.Size(), 0)
+		opts.observe(Observer.OnFinish, ev)
+		opts.checkSlow(ev, st)
+		return
+	}
+
+	ev.TimedOut = true
+	opts.Registry.setState(st, StateWriting)
+	if errors.Is(context.Cause(ctx), context.Canceled) {
+		c.Writer.WriteHeader(StatusClientClosedRequest)
+		ev.Status = StatusClientClosedRequest
+		opts.notify(Observer.OnClientGone, ev)
+		opts.notify(Observer.OnLateFinish, ev)
+		return
+	}
+
+	// the timeout response gets the complete timing headers instead
+	h := c.Writer.Header()
+	if i := slices.Index(h["Server-Timing"], budget); budget != "" && i >= 0 {
+		h["Server-Timing"] = slices.Delete(h["Server-Timing"], i, i+1)
+		if len(h["Server-Timing"]) == 0 {
+			h.Del("Server-Timing")
+		}
+	}
+
+	// the handlers' headers are already in c.Writer.Header(), and the
+	// Response is given the request carrying the deadline, like in
+	// goroutine mode, see stateFrom.
+	rc := c.Copy()
+	rc.Request = dreq
+	n, err := writeTimeoutResponse(c.Writer, rc, opts, st, nil)
+	ev.Status, ev.Size = c.Writer.Status(), n
+	if err != nil {
+		opts.logError("gin-timeout: write timeout response", ev, err)
+	}
+	opts.notify(Observer.OnTimeout, ev)
+	// the handlers returned after the deadline
+	opts.notify(Observer.OnLateFinish, ev)
+}
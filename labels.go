@@ -0,0 +1,53 @@
+package timeout
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The pprof labels of the handler goroutine,
+// so that CPU and goroutine profiles can be split by endpoint.
+//
+// LabelTimedOut is "false" until the handlers write, set the status or
+// call Extend after the timeout. The labels of a goroutine can only be
+// changed by the goroutine itself, so a handler that never does any of
+// these after the timeout keeps "false", the Registry lists those requests.
+// Inline mode sets no labels.
+const (
+	LabelRoute    = "route"
+	LabelMethod   = "method"
+	LabelTimedOut = "timed_out"
+)
+
+// next runs the handlers under pprof.Do. The labels of c.Request's context,
+// e.g. set by a profiling middleware registered before us, are kept.
+func (tw *TimeoutWriter) next(c *gin.Context, st *requestState) {
+	labels := []string{
+		LabelRoute, c.FullPath(),
+		LabelMethod, c.Request.Method,
+		LabelTimedOut, "false",
+	}
+	if tw.sampler != nil {
+		labels = append(labels, labelRequestID, strconv.FormatUint(st.id, 10))
+	}
+	pprof.Do(c.Request.Context(), pprof.Labels(labels...), func(ctx context.Context) {
+		tw.labels = ctx
+		c.Next()
+	})
+}
+
+// relabel sets the timed_out label of the calling goroutine,
+// which is assumed to be the handler's. tw.mu must be held.
+//
+// Goroutine labels cannot be changed from another goroutine, so this is
+// only done when the handlers write or call Extend after the timeout.
+func (tw *TimeoutWriter) relabel() {
+	if tw.labels == nil || tw.relabeled {
+		return
+	}
+	tw.relabeled = true
+	pprof.SetGoroutineLabels(pprof.WithLabels(tw.labels, pprof.Labels(LabelTimedOut, "true")))
+}
@@ -0,0 +1,72 @@
+package timeout
+
+import (
+	"log/slog"
+)
+
+// logObserver logs what happens to the handlers once the middleware
+// stopped waiting for them, see WithLogger.
+type logObserver struct {
+	NopObserver
+	logger *slog.Logger
+}
+
+func (o *logObserver) log(level slog.Level, msg string, ev TimeoutEvent, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{
+		slog.String("route", ev.FullPath),
+		slog.String("method", ev.Method),
+		slog.Int("status", ev.Status),
+		slog.Duration("elapsed", ev.Elapsed),
+		slog.Duration("timeout", ev.Timeout+ev.Extended),
+		slog.String("request_id", ev.RequestID),
+	}, attrs...)
+	o.logger.LogAttrs(ev.Context(), level, msg, attrs...)
+}
+
+func (o *logObserver) OnTimeout(ev TimeoutEvent) {
+	if ev.Stack != "" {
+		o.log(slog.LevelWarn, "gin-timeout: handler timeout", ev, slog.String("stack", ev.Stack))
+		return
+	}
+	o.log(slog.LevelWarn, "gin-timeout: handler timeout", ev)
+}
+
+func (o *logObserver) OnClientGone(ev TimeoutEvent) {
+	o.log(slog.LevelDebug, "gin-timeout: client gone", ev)
+}
+
+func (o *logObserver) OnSlow(ev TimeoutEvent) {
+	attrs := []slog.Attr{slog.Duration("slow_threshold", ev.SlowThreshold)}
+	if ev.Stack != "" {
+		attrs = append(attrs, slog.String("stack", ev.Stack))
+	}
+	o.log(slog.LevelInfo, "gin-timeout: slow request", ev, attrs...)
+}
+
+func (o *logObserver) OnLateFinish(ev TimeoutEvent) {
+	o.log(slog.LevelInfo, "gin-timeout: handler finished after timeout", ev)
+}
+
+// OnPanic only logs the panics after the timeout,
+// the others are re-panicked for the recovery middleware.
+func (o *logObserver) OnPanic(ev TimeoutEvent) {
+	if ev.TimedOut {
+		o.log(slog.LevelError, "gin-timeout: handler panicked after timeout", ev,
+			slog.Any("panic", ev.Panic))
+	}
+}
+
+// logError logs a failure of the middleware itself.
+func (o *TimeoutOptions) logError(msg string, ev TimeoutEvent, err error) {
+	if o.Logger != nil {
+		(&logObserver{logger: o.Logger}).log(slog.LevelError, msg, ev, slog.Any("error", err))
+	}
+}
+
+// logOverflow logs that the handlers wrote more than MaxBufferSize.
+func (o *TimeoutOptions) logOverflow(ev TimeoutEvent) {
+	if o.Logger != nil {
+		(&logObserver{logger: o.Logger}).log(slog.LevelWarn, "gin-timeout: response buffer overflow", ev,
+			slog.Int("max_buffer_size", o.MaxBufferSize))
+	}
+}
@@ -0,0 +1,170 @@
+// Package metrics provides a Prometheus collector for the Timeout middleware.
+//
+//	collector := metrics.NewCollector("myapp")
+//	prometheus.MustRegister(collector)
+//	engine.Use(timeout.Timeout(timeout.WithObserver(collector)))
+//
+// It is a module of its own, so that only its users depend on Prometheus.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	timeout "github.com/vearne/gin-timeout"
+)
+
+// Outcomes of a request, used as the "outcome" label of the duration histogram.
+const (
+	OutcomeFinished   = "finished"
+	OutcomeTimeout    = "timeout"
+	OutcomePanic      = "panic"
+	OutcomeClientGone = "client_gone"
+)
+
+// Collector is both a timeout.Observer and a prometheus.Collector.
+// All the metrics are labeled by route pattern and method.
+type Collector struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	timeouts      *prometheus.CounterVec
+	panics        *prometheus.CounterVec
+	lateFinishes  *prometheus.CounterVec
+	clientGone    *prometheus.CounterVec
+	orphans       *prometheus.GaugeVec
+	bufferedBytes *prometheus.HistogramVec
+	extended      *prometheus.CounterVec
+	slow          *prometheus.CounterVec
+}
+
+var _ timeout.SlowObserver = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector creates the metrics, prefixed with namespace if it is not empty.
+func NewCollector(namespace string) *Collector {
+	labels := []string{"route", "method"}
+	const subsystem = "gin_timeout"
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "requests_total",
+			Help: "Requests handled by the Timeout middleware.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name:    "request_duration_seconds",
+			Help:    "Time until the Timeout middleware responded, by outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, append(labels, "outcome")),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "timeouts_total",
+			Help: "Requests answered with the timeout response.",
+		}, labels),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "panics_total",
+			Help: "Handler panics, including those after the timeout.",
+		}, labels),
+		lateFinishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "late_finishes_total",
+			Help: "Handlers that returned after the timeout or the client went away.",
+		}, labels),
+		clientGone: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "client_gone_total",
+			Help: "Requests cancelled by the client before the timeout.",
+		}, labels),
+		orphans: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "orphan_goroutines",
+			Help: "Handlers still running after the middleware stopped waiting for them.",
+		}, labels),
+		bufferedBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name:    "buffered_bytes",
+			Help:    "Size of the response bodies buffered by the Timeout middleware.",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		}, labels),
+		extended: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "extended_seconds_total",
+			Help: "Time added to the timeout by the handlers with timeout.Extend.",
+		}, labels),
+		slow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "slow_requests_total",
+			Help: "Requests that finished after the slow threshold, see timeout.WithSlowRatio.",
+		}, labels),
+	}
+}
+
+func (m *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests, m.duration, m.timeouts, m.panics, m.lateFinishes,
+		m.clientGone, m.orphans, m.bufferedBytes, m.extended, m.slow,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// done records a request the middleware responded to.
+func (m *Collector) done(ev timeout.TimeoutEvent, outcome string) {
+	m.requests.WithLabelValues(ev.FullPath, ev.Method).Inc()
+	m.duration.WithLabelValues(ev.FullPath, ev.Method, outcome).Observe(ev.Elapsed.Seconds())
+	if ev.Extended > 0 {
+		m.extended.WithLabelValues(ev.FullPath, ev.Method).Add(ev.Extended.Seconds())
+	}
+}
+
+func (m *Collector) OnStart(timeout.TimeoutEvent) {}
+
+func (m *Collector) OnFinish(ev timeout.TimeoutEvent) {
+	m.done(ev, OutcomeFinished)
+	// inline mode buffers nothing
+	if ev.Buffered {
+		m.bufferedBytes.WithLabelValues(ev.FullPath, ev.Method).Observe(float64(ev.Size))
+	}
+}
+
+func (m *Collector) OnTimeout(ev timeout.TimeoutEvent) {
+	m.done(ev, OutcomeTimeout)
+	m.timeouts.WithLabelValues(ev.FullPath, ev.Method).Inc()
+	m.orphans.WithLabelValues(ev.FullPath, ev.Method).Inc()
+}
+
+func (m *Collector) OnPanic(ev timeout.TimeoutEvent) {
+	m.panics.WithLabelValues(ev.FullPath, ev.Method).Inc()
+	if ev.TimedOut {
+		m.orphans.WithLabelValues(ev.FullPath, ev.Method).Dec()
+		return
+	}
+	m.done(ev, OutcomePanic)
+}
+
+func (m *Collector) OnLateFinish(ev timeout.TimeoutEvent) {
+	m.lateFinishes.WithLabelValues(ev.FullPath, ev.Method).Inc()
+	m.orphans.WithLabelValues(ev.FullPath, ev.Method).Dec()
+}
+
+func (m *Collector) OnClientGone(ev timeout.TimeoutEvent) {
+	m.done(ev, OutcomeClientGone)
+	m.clientGone.WithLabelValues(ev.FullPath, ev.Method).Inc()
+	m.orphans.WithLabelValues(ev.FullPath, ev.Method).Inc()
+}
+
+func (m *Collector) OnSlow(ev timeout.TimeoutEvent) {
+	m.slow.WithLabelValues(ev.FullPath, ev.Method).Inc()
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	timeout "github.com/vearne/gin-timeout"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector("test")
+	lateFinish := make(chan struct{})
+
+	router := gin.New()
+	router.Use(timeout.Timeout(
+		timeout.WithTimeout(50*time.Millisecond),
+		timeout.WithObserver(collector),
+	))
+	router.GET("/short/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "short")
+	})
+	router.GET("/long", func(c *gin.Context) {
+		defer close(lateFinish)
+		<-c.Request.Context().Done()
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, 1.0, testutil.ToFloat64(collector.orphans.WithLabelValues("/long", "GET")))
+	})
+
+	for _, path := range []string{"/short/1", "/short/2", "/long"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	<-lateFinish
+	// OnLateFinish is called after the handler returned
+	time.Sleep(10 * time.Millisecond)
+
+	expected := `
+# HELP test_gin_timeout_requests_total Requests handled by the Timeout middleware.
+# TYPE test_gin_timeout_requests_total counter
+test_gin_timeout_requests_total{method="GET",route="/long"} 1
+test_gin_timeout_requests_total{method="GET",route="/short/:id"} 2
+# HELP test_gin_timeout_timeouts_total Requests answered with the timeout response.
+# TYPE test_gin_timeout_timeouts_total counter
+test_gin_timeout_timeouts_total{method="GET",route="/long"} 1
+# HELP test_gin_timeout_late_finishes_total Handlers that returned after the timeout or the client went away.
+# TYPE test_gin_timeout_late_finishes_total counter
+test_gin_timeout_late_finishes_total{method="GET",route="/long"} 1
+# HELP test_gin_timeout_orphan_goroutines Handlers still running after the middleware stopped waiting for them.
+# TYPE test_gin_timeout_orphan_goroutines gauge
+test_gin_timeout_orphan_goroutines{method="GET",route="/long"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"test_gin_timeout_requests_total",
+		"test_gin_timeout_timeouts_total",
+		"test_gin_timeout_late_finishes_total",
+		"test_gin_timeout_orphan_goroutines",
+	))
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "test_gin_timeout_request_duration_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "test_gin_timeout_buffered_bytes"))
+
+	// inline mode buffers nothing
+	router = gin.New()
+	router.Use(timeout.Timeout(timeout.WithInline(true), timeout.WithObserver(collector)))
+	router.GET("/inline", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/inline", nil))
+	assert.Equal(t, 3, testutil.CollectAndCount(collector, "test_gin_timeout_request_duration_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "test_gin_timeout_buffered_bytes"))
+}
+
+func TestCollectorSlow(t *testing.T) {
+	collector := NewCollector("slow")
+	router := gin.New()
+	router.Use(timeout.Timeout(
+		timeout.WithTimeout(100*time.Millisecond),
+		timeout.WithSlowRatio(0.2),
+		timeout.WithObserver(collector),
+	))
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+	})
+	router.GET("/fast", func(c *gin.Context) {})
+
+	for _, path := range []string{"/slow", "/fast", "/slow"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	expected := `
+# HELP slow_gin_timeout_slow_requests_total Requests that finished after the slow threshold, see timeout.WithSlowRatio.
+# TYPE slow_gin_timeout_slow_requests_total counter
+slow_gin_timeout_slow_requests_total{method="GET",route="/slow"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"slow_gin_timeout_slow_requests_total"))
+}
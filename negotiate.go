@@ -0,0 +1,69 @@
+package timeout
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptItem is an entry of an Accept or Accept-Language header.
+type acceptItem struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses an Accept style header, the values are lower case and
+// without parameters. They are sorted by decreasing quality, so the
+// entries with q=0, which are not acceptable, come last.
+func parseAccept(header string) []acceptItem {
+	var items []acceptItem
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(k) != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+		items = append(items, acceptItem{value: value, q: q})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].q > items[j].q
+	})
+	return items
+}
+
+// rejected reports whether value is explicitly not acceptable, with q=0.
+func rejected(items []acceptItem, value string) bool {
+	for _, item := range items {
+		if item.q == 0 && item.value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the media type of contentType, without parameters.
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// matchMediaType reports whether the media range accepted,
+// e.g. text/*, matches the content type offered.
+func matchMediaType(accepted, offered string) bool {
+	offered = mediaType(offered)
+	if accepted == "*/*" || accepted == offered {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(accepted, "/*")
+	return ok && strings.HasPrefix(offered, prefix+"/")
+}
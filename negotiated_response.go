@@ -0,0 +1,109 @@
+package timeout
+
+import (
+	"encoding/xml"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Format is a representation of the timeout response, see NegotiatedResponse.
+type Format struct {
+	// ContentType is matched against the Accept header of the request.
+	ContentType string
+	// Content is encoded like the Content of BaseResponse,
+	// according to ContentType, see WithEncoder.
+	Content any
+}
+
+// NegotiatedResponse picks the Format of the timeout response
+// from the Accept header of the request, and sets Vary: Accept
+// so that caches do not serve one format to every client.
+// The first of the Formats is used if none is acceptable.
+type NegotiatedResponse struct {
+	Code    int
+	Formats []Format
+}
+
+// NewNegotiatedResponse offers msg as JSON, XML, HTML, plain text and
+// protobuf, JSON being the default. The protobuf message is a
+// google.protobuf.Struct with the fields of the JSON object. Prepend a
+// Format to use a message of your own:
+//
+//	resp.Formats = append([]timeout.Format{{
+//		ContentType: "application/x-protobuf",
+//		Content:     &pb.Error{Message: msg},
+//	}}, resp.Formats...)
+func NewNegotiatedResponse(code int, msg string) *NegotiatedResponse {
+	var x strings.Builder
+	_ = xml.EscapeText(&x, []byte(msg))
+	pb := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"code": structpb.NewNumberValue(-1),
+		"msg":  structpb.NewStringValue(msg),
+	}}
+	return &NegotiatedResponse{
+		Code: code,
+		Formats: []Format{
+			{
+				ContentType: "application/json; charset=utf-8",
+				Content:     gin.H{"code": -1, "msg": msg},
+			},
+			{
+				ContentType: "application/xml; charset=utf-8",
+				Content:     "<error><code>-1</code><msg>" + x.String() + "</msg></error>",
+			},
+			{
+				ContentType: "text/html; charset=utf-8",
+				Content: "<!DOCTYPE html><html><head><title>" + html.EscapeString(msg) +
+					"</title></head><body><p>" + html.EscapeString(msg) + "</p></body></html>",
+			},
+			{
+				ContentType: "text/plain; charset=utf-8",
+				Content:     msg,
+			},
+			{
+				ContentType: "application/x-protobuf",
+				Content:     pb,
+			},
+		},
+	}
+}
+
+// negotiate returns the Format to respond with.
+func (r *NegotiatedResponse) negotiate(c *gin.Context) Format {
+	if len(r.Formats) == 0 {
+		return Format{}
+	}
+	accept := parseAccept(c.GetHeader("Accept"))
+	for _, accepted := range accept {
+		if accepted.q == 0 {
+			break
+		}
+		for _, f := range r.Formats {
+			if matchMediaType(accepted.value, f.ContentType) && !rejected(accept, mediaType(f.ContentType)) {
+				return f
+			}
+		}
+	}
+	return r.Formats[0]
+}
+
+func (r *NegotiatedResponse) GetCode(c *gin.Context) int {
+	return r.Code
+}
+
+func (r *NegotiatedResponse) GetContent(c *gin.Context) any {
+	return r.negotiate(c).Content
+}
+
+func (r *NegotiatedResponse) GetContentType(c *gin.Context) string {
+	return r.negotiate(c).ContentType
+}
+
+// GetHeaders implements HeaderResponse.
+func (r *NegotiatedResponse) GetHeaders(c *gin.Context) http.Header {
+	return http.Header{"Vary": {"Accept"}}
+}
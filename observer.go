@@ -0,0 +1,202 @@
+package timeout
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Observer is notified of the lifecycle of the requests handled by Timeout.
+// Embed NopObserver to implement only some of the hooks.
+//
+// OnStart, OnFinish and OnPanic, unless it happens after the timeout,
+// are called on the request goroutine. The other hooks are called on the
+// Dispatcher configured with WithDispatcher, if any.
+//
+// Every OnTimeout or OnClientGone is followed by either OnLateFinish,
+// or OnPanic with ev.TimedOut set, once the handlers return.
+// The latter is only notified after the former returned, or was queued
+// on the Dispatcher, which runs them in order if it has a single worker.
+type Observer interface {
+	// OnStart is called before the handlers run.
+	OnStart(ev TimeoutEvent)
+	// OnFinish is called when the handlers finish before the timeout,
+	// after their response has been written.
+	OnFinish(ev TimeoutEvent)
+	// OnTimeout is called after the timeout response has been written.
+	OnTimeout(ev TimeoutEvent)
+	// OnPanic is called when the handlers panic, ev.Panic holds the value
+	// and ev.TimedOut tells whether the timeout had expired before.
+	OnPanic(ev TimeoutEvent)
+	// OnLateFinish is called when the handlers finish after
+	// the timeout expired or the client went away.
+	OnLateFinish(ev TimeoutEvent)
+	// OnClientGone is called when the client cancels the request
+	// before the timeout expires.
+	OnClientGone(ev TimeoutEvent)
+}
+
+// ContextObserver is an Observer that also derives the context the handlers
+// run with, for instance to start a span covering them.
+// TimeoutEvent.Context returns the derived context in the following hooks.
+type ContextObserver interface {
+	Observer
+	HandlerContext(ctx context.Context, ev TimeoutEvent) context.Context
+}
+
+// NopObserver is an Observer whose hooks do nothing.
+type NopObserver struct{}
+
+func (NopObserver) OnStart(TimeoutEvent)      {}
+func (NopObserver) OnFinish(TimeoutEvent)     {}
+func (NopObserver) OnTimeout(TimeoutEvent)    {}
+func (NopObserver) OnPanic(TimeoutEvent)      {}
+func (NopObserver) OnLateFinish(TimeoutEvent) {}
+func (NopObserver) OnClientGone(TimeoutEvent) {}
+
+// observers notifies each of its Observers in turn.
+type observers []Observer
+
+func (os observers) OnStart(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnStart(ev)
+	}
+}
+
+func (os observers) OnFinish(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnFinish(ev)
+	}
+}
+
+func (os observers) OnTimeout(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnTimeout(ev)
+	}
+}
+
+func (os observers) OnPanic(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnPanic(ev)
+	}
+}
+
+func (os observers) OnLateFinish(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnLateFinish(ev)
+	}
+}
+
+func (os observers) OnClientGone(ev TimeoutEvent) {
+	for _, o := range os {
+		o.OnClientGone(ev)
+	}
+}
+
+// callbackObserver adapts the callbacks of TimeoutOptions to Observer.
+type callbackObserver struct {
+	NopObserver
+	callBack        CallBackFunc
+	ginCtxCallBack  GinCtxCallBackFunc
+	timeoutCallBack TimeoutCallBackFunc
+	onClientGone    CallBackFunc
+}
+
+func (o *callbackObserver) OnTimeout(ev TimeoutEvent) {
+	if o.callBack != nil {
+		o.callBack(ev.req)
+	}
+	if o.ginCtxCallBack != nil {
+		o.ginCtxCallBack(ev.ginCtx)
+	}
+	if o.timeoutCallBack != nil {
+		o.timeoutCallBack(ev)
+	}
+}
+
+func (o *callbackObserver) OnClientGone(ev TimeoutEvent) {
+	if o.onClientGone != nil {
+		o.onClientGone(ev.req)
+	}
+}
+
+// buildObserver combines the callbacks and the Observers of o,
+// it returns nil if there are none.
+func (o *TimeoutOptions) buildObserver() Observer {
+	var os observers
+	if o.CallBack != nil || o.GinCtxCallBack != nil || o.TimeoutCallBack != nil || o.OnClientGone != nil {
+		os = append(os, &callbackObserver{
+			callBack:        o.CallBack,
+			ginCtxCallBack:  o.GinCtxCallBack,
+			timeoutCallBack: o.TimeoutCallBack,
+			onClientGone:    o.OnClientGone,
+		})
+	}
+	if o.Logger != nil {
+		os = append(os, &logObserver{logger: o.Logger})
+	}
+	os = append(os, o.Observers...)
+
+	o.contextObservers = nil
+	for _, obs := range o.Observers {
+		if co, ok := obs.(ContextObserver); ok {
+			o.contextObservers = append(o.contextObservers, co)
+		}
+	}
+
+	switch len(os) {
+	case 0:
+		return nil
+	case 1:
+		return os[0]
+	default:
+		return os
+	}
+}
+
+// newEvent returns the event describing the request, if anyone observes it.
+// c must be the gin.Context the middleware was called with,
+// and req the request with the deadline.
+// The returned request is the one the handlers must run with.
+func (o *TimeoutOptions) newEvent(c *gin.Context, req *http.Request, st *requestState) (TimeoutEvent, *http.Request) {
+	if o.observer == nil {
+		return TimeoutEvent{}, req
+	}
+	ev := newTimeoutEvent(c, o, st)
+	if len(o.contextObservers) > 0 {
+		ctx := req.Context()
+		for _, co := range o.contextObservers {
+			ctx = co.HandlerContext(ctx, ev)
+		}
+		req = req.WithContext(ctx)
+	}
+	ev.req = req
+	ev.ginCtx = c
+	// c is reused by gin once the middleware returns
+	if o.Dispatcher != nil && o.GinCtxCallBack != nil {
+		ev.ginCtx = c.Copy()
+	}
+	return ev, req
+}
+
+// observe calls hook with ev right away.
+func (o *TimeoutOptions) observe(hook func(Observer, TimeoutEvent), ev TimeoutEvent) {
+	if o.observer != nil {
+		hook(o.observer, ev)
+	}
+}
+
+// notify calls hook with ev on the Dispatcher if there is one, or right away.
+func (o *TimeoutOptions) notify(hook func(Observer, TimeoutEvent), ev TimeoutEvent) {
+	if o.observer == nil {
+		return
+	}
+	if o.Dispatcher == nil {
+		hook(o.observer, ev)
+		return
+	}
+	o.Dispatcher.Dispatch(func() {
+		hook(o.observer, ev)
+	})
+}
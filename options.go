@@ -1,6 +1,7 @@
 package timeout
 
 import (
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -9,13 +10,45 @@ import (
 
 type CallBackFunc func(*http.Request)
 type GinCtxCallBackFunc func(*gin.Context)
+type TimeoutCallBackFunc func(TimeoutEvent)
 type Option func(*TimeoutWriter)
 
 type TimeoutOptions struct {
-	CallBack       CallBackFunc
-	GinCtxCallBack GinCtxCallBackFunc
-	Timeout        time.Duration
-	Response       Response
+	CallBack         CallBackFunc
+	GinCtxCallBack   GinCtxCallBackFunc
+	TimeoutCallBack  TimeoutCallBackFunc
+	EventHeaders     []string
+	RequestIDHeader  string
+	Dispatcher       *Dispatcher
+	Observers        []Observer
+	Logger           *slog.Logger
+	Registry         *Registry
+	MaxBufferSize    int
+	OnClientGone     CallBackFunc
+	ReturnWriteError bool
+	MaxExtension     time.Duration
+	MaxExtendCount   int
+	Inline           bool
+	TimingHeaders    bool
+	SlowRatio        float64
+	TimeoutHeaders   http.Header
+	RetryAfter       time.Duration
+	CopyHeaders      []string
+	StackSampleRate  float64
+	StackInterval    time.Duration
+	Timeout          time.Duration
+	Response         Response
+	Renderer         Renderer
+	Encoders         map[string]Encoder
+
+	// observer combines the callbacks and Observers, see buildObserver
+	observer         Observer
+	contextObservers []ContextObserver
+	sampler          *stackSampler
+	// set by WithErrorHttpCode, WithDefaultMsg and WithContentType
+	override responseOverride
+	// Renderer, or Response adapted to Renderer
+	render Renderer
 }
 
 func WithTimeout(d time.Duration) Option {
@@ -25,32 +58,37 @@ func WithTimeout(d time.Duration) Option {
 }
 
 // Optional parameters
+// The status code of the timeout response, whatever the Response.
 func WithErrorHttpCode(code int) Option {
 	return func(t *TimeoutWriter) {
-		if t.Response == nil {
-			t.Response = defaultResponse
-		}
-		t.Response.SetCode(code)
+		t.override.code = code
 	}
 }
 
 // Optional parameters
+// The body of the timeout response, whatever the Response.
 func WithDefaultMsg(resp interface{}) Option {
 	return func(t *TimeoutWriter) {
-		if t.Response == nil {
-			t.Response = defaultResponse
-		}
-		t.Response.SetContent(resp)
+		t.override.content = resp
+		t.override.hasContent = true
 	}
 }
 
 // Optional parameters
+// The content type of the timeout response, whatever the Response.
 func WithContentType(ct string) Option {
 	return func(t *TimeoutWriter) {
-		if t.Response == nil {
-			t.Response = defaultResponse
-		}
-		t.Response.SetContentType(ct)
+		t.override.contentType = ct
+	}
+}
+
+// Optional parameters
+// If b is true, writes made by the handler after the timeout return
+// ErrHandlerTimeout instead of silently succeeding, like http.TimeoutHandler.
+// If the client went away, the error is the cause of the cancellation.
+func WithReturnWriteError(b bool) Option {
+	return func(t *TimeoutWriter) {
+		t.ReturnWriteError = b
 	}
 }
 
@@ -75,3 +113,202 @@ func WithGinCtxCallBack(f GinCtxCallBackFunc) Option {
 		t.GinCtxCallBack = f
 	}
 }
+
+// Optional parameters
+// f is called with a snapshot of the request when the timeout expires.
+// Unlike WithCallBack and WithGinCtxCallBack, nothing it receives
+// is shared with the handler, which may still be running.
+func WithTimeoutCallBack(f TimeoutCallBackFunc) Option {
+	return func(t *TimeoutWriter) {
+		t.TimeoutCallBack = f
+	}
+}
+
+// Optional parameters
+// The request headers copied into TimeoutEvent.Header
+func WithEventHeaders(keys ...string) Option {
+	return func(t *TimeoutWriter) {
+		t.EventHeaders = keys
+	}
+}
+
+// Optional parameters
+// The request header TimeoutEvent.RequestID is read from,
+// X-Request-ID by default.
+func WithRequestIDHeader(key string) Option {
+	return func(t *TimeoutWriter) {
+		t.RequestIDHeader = key
+	}
+}
+
+// Optional parameters
+// f is called instead of the timeout callbacks when the client
+// cancels the request before the timeout expires.
+func WithOnClientGone(f CallBackFunc) Option {
+	return func(t *TimeoutWriter) {
+		t.OnClientGone = f
+	}
+}
+
+// Optional parameters
+// Allow handlers to push their deadline out with Extend,
+// by at most total in all and at most count times per request.
+// By default, the deadline cannot be extended.
+func WithExtendLimit(total time.Duration, count int) Option {
+	return func(t *TimeoutWriter) {
+		t.MaxExtension = total
+		t.MaxExtendCount = count
+	}
+}
+
+// Optional parameters
+// If b is true, the handlers run on the calling goroutine and are only
+// given a deadline on c.Request's context. The timeout response is written
+// if they return after the deadline without writing a response.
+// There is no preemption, so handlers must respect ctx.Done(),
+// but there is no goroutine, no copy of the gin.Context and no buffering.
+func WithInline(b bool) Option {
+	return func(t *TimeoutWriter) {
+		t.Inline = b
+	}
+}
+
+// Optional parameters
+// Run the callbacks on d instead of the request goroutine,
+// so that a slow callback does not delay the timeout response.
+// d can be shared by several Timeout middlewares.
+func WithDispatcher(d *Dispatcher) Option {
+	return func(t *TimeoutWriter) {
+		t.Dispatcher = d
+	}
+}
+
+// Optional parameters
+// The Observers are notified of the lifecycle of every request,
+// in addition to the callbacks.
+func WithObserver(obs ...Observer) Option {
+	return func(t *TimeoutWriter) {
+		t.Observers = append(t.Observers, obs...)
+	}
+}
+
+// Optional parameters
+// The middleware logs timeouts, late completions, late panics,
+// buffer overflows and write errors to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(t *TimeoutWriter) {
+		t.Logger = logger
+	}
+}
+
+// Optional parameters
+// Writes that would make the buffered body larger than n bytes
+// fail with ErrBufferOverflow, and the body written so far is replaced
+// with a 500 Internal Server Error. By default, the body is not limited.
+func WithMaxBufferSize(n int) Option {
+	return func(t *TimeoutWriter) {
+		t.MaxBufferSize = n
+	}
+}
+
+// Optional parameters
+// At timeout, capture the stack of the handler goroutine into
+// TimeoutEvent.Stack, for a fraction rate of the timeouts and
+// at most once per interval. Capturing reads the goroutine profile,
+// which briefly stops the world. It has no effect with WithInline.
+func WithStackCapture(rate float64, interval time.Duration) Option {
+	return func(t *TimeoutWriter) {
+		t.StackSampleRate = rate
+		t.StackInterval = interval
+	}
+}
+
+// Optional parameters
+// The requests are listed in r while they are inside the middleware,
+// see Registry.Handler.
+func WithRegistry(r *Registry) Option {
+	return func(t *TimeoutWriter) {
+		t.Registry = r
+	}
+}
+
+// Optional parameters
+// If b is true, the responses get the X-Timeout-Budget and
+// X-Timeout-Remaining headers, and the timeout-budget and timeout-elapsed
+// metrics are added to the Server-Timing header.
+// With WithInline, the handlers commit the other responses, so these only
+// get X-Timeout-Budget and the timeout-budget metric, set before the
+// handlers run, without the extensions made with Extend.
+func WithTimingHeaders(b bool) Option {
+	return func(t *TimeoutWriter) {
+		t.TimingHeaders = b
+	}
+}
+
+// Optional parameters
+// Requests that finish before the timeout, but after ratio times
+// the timeout, e.g. 0.5, are reported to the SlowObserver.OnSlow hooks.
+// With WithStackCapture, the stack of the handler goroutine is captured
+// when the threshold is crossed, it is subject to the same sampling.
+func WithSlowRatio(ratio float64) Option {
+	return func(t *TimeoutWriter) {
+		t.SlowRatio = ratio
+	}
+}
+
+// Optional parameters
+// The headers are set on the timeout response,
+// e.g. Cache-Control: no-store or Connection: close.
+func WithTimeoutHeaders(h http.Header) Option {
+	return func(t *TimeoutWriter) {
+		t.TimeoutHeaders = h.Clone()
+	}
+}
+
+// Optional parameters
+// Set Retry-After on the timeout response, d is rounded up to seconds.
+// Implement HeaderResponse to compute it for every request.
+func WithRetryAfter(d time.Duration) Option {
+	return func(t *TimeoutWriter) {
+		t.RetryAfter = d
+	}
+}
+
+// Optional parameters
+// The headers the handlers set before the timeout, among keys,
+// are copied onto the timeout response, e.g. the CORS headers,
+// so that browsers can read it.
+// Since the handlers may still be modifying them, the headers are copied
+// when the handlers set them with SetHeader. Those set with c.Header are
+// only copied the next time the handlers call Header, WriteHeader or Write,
+// so a handler blocking right after c.Header misses the timeout response.
+func WithCopyHeaders(keys ...string) Option {
+	return func(t *TimeoutWriter) {
+		t.CopyHeaders = keys
+	}
+}
+
+// Optional parameters
+// r writes the timeout response instead of the Response,
+// WithErrorHttpCode, WithDefaultMsg and WithContentType are ignored.
+func WithRenderer(r Renderer) Option {
+	return func(t *TimeoutWriter) {
+		t.Renderer = r
+	}
+}
+
+// Optional parameters
+// The content of the Response is encoded with enc when its content type
+// is contentType, unless it is a string or a []byte. JSON, XML, YAML, TOML,
+// protobuf and msgpack (unless built with nomsgpack) are supported
+// by default, JSON being used for the other content types.
+func WithEncoder(contentType string, enc Encoder) Option {
+	return func(t *TimeoutWriter) {
+		encoders := make(map[string]Encoder, len(t.Encoders)+1)
+		for k, v := range t.Encoders {
+			encoders[k] = v
+		}
+		encoders[mediaType(contentType)] = enc
+		t.Encoders = encoders
+	}
+}
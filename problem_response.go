@@ -0,0 +1,93 @@
+package timeout
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentTypeProblem is the content type of RFC 7807 problem details.
+const ContentTypeProblem = "application/problem+json"
+
+// ProblemResponse renders the timeout response as RFC 7807 problem details:
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Service Unavailable",
+//	  "status": 503,
+//	  "detail": "the request did not complete within 3s",
+//	  "instance": "/user/42",
+//	  "timeout_ms": 3000,
+//	  "request_id": "abc",
+//	  "retry_after": 5
+//	}
+//
+// timeout_ms includes the extensions, see Extend, and request_id is read
+// from the header set with WithRequestIDHeader, it is omitted if empty.
+type ProblemResponse struct {
+	// Status defaults to 503
+	Status int
+	// Type defaults to about:blank
+	Type string
+	// Title defaults to the status text
+	Title string
+	// Detail defaults to a sentence giving the timeout
+	Detail string
+	// RetryAfter is the retry_after hint in seconds, it is omitted if 0.
+	RetryAfter time.Duration
+	// Extensions are added to the problem details,
+	// they cannot replace the members above.
+	Extensions map[string]any
+}
+
+func (r *ProblemResponse) GetCode(c *gin.Context) int {
+	if r.Status == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return r.Status
+}
+
+func (r *ProblemResponse) GetContent(c *gin.Context) any {
+	status := r.GetCode(c)
+	problem := make(map[string]any, len(r.Extensions)+8)
+	for k, v := range r.Extensions {
+		problem[k] = v
+	}
+	problem["type"] = r.Type
+	if r.Type == "" {
+		problem["type"] = "about:blank"
+	}
+	problem["title"] = r.Title
+	if r.Title == "" {
+		problem["title"] = http.StatusText(status)
+	}
+	problem["status"] = status
+	problem["instance"] = c.Request.URL.Path
+
+	detail := r.Detail
+	if st, ok := stateFrom(c.Request.Context()); ok {
+		budget := st.timeout + st.getExtended()
+		problem["timeout_ms"] = budget.Milliseconds()
+		if detail == "" {
+			detail = "the request did not complete within " + budget.String()
+		}
+		if st.requestIDHeader != "" {
+			if id := c.GetHeader(st.requestIDHeader); id != "" {
+				problem["request_id"] = id
+			}
+		}
+	}
+	if detail != "" {
+		problem["detail"] = detail
+	}
+	if r.RetryAfter > 0 {
+		problem["retry_after"] = int64(math.Ceil(r.RetryAfter.Seconds()))
+	}
+	return problem
+}
+
+func (r *ProblemResponse) GetContentType(c *gin.Context) string {
+	return ContentTypeProblem
+}
@@ -0,0 +1,108 @@
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// The tests in this file are meant to be run with `go test -race`,
+// they exercise the paths where the handlers and the middleware
+// may touch the same data concurrently.
+
+// raceEngine returns an engine whose handler keeps using its gin.Context
+// after the timeout, while the outer middleware uses the original one.
+func raceEngine(orphans *sync.WaitGroup, opts ...Option) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("outer", true)
+		c.Next()
+		_ = c.Keys["outer"]
+		_ = c.Errors.String()
+		_ = c.Writer.Status()
+		_ = c.Writer.Size()
+	})
+	router.Use(Timeout(append([]Option{WithTimeout(20 * time.Millisecond)}, opts...)...))
+	router.GET("/race/:id", func(c *gin.Context) {
+		defer orphans.Done()
+		time.Sleep(time.Duration(len(c.Param("id"))) * 10 * time.Millisecond)
+		for i := 0; i < 10; i++ {
+			c.Set("inner", i)
+			_ = c.Error(ErrHandlerTimeout)
+			c.Header("X-Inner", "1")
+			_, _ = c.Writer.Write([]byte("late"))
+			_ = c.Writer.Status()
+			_ = c.Writer.Size()
+			_ = c.Writer.Written()
+			_ = c.Param("id")
+			_, _ = Remaining(c)
+		}
+		c.String(http.StatusOK, "done")
+	})
+	return router
+}
+
+func serveConcurrently(router *gin.Engine, n int, paths ...string) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}(paths[i%len(paths)])
+	}
+	wg.Wait()
+}
+
+func TestRaceOrphanHandler(t *testing.T) {
+	var orphans sync.WaitGroup
+	orphans.Add(40)
+	router := raceEngine(&orphans,
+		WithCallBack(func(r *http.Request) {
+			_ = r.URL.String()
+		}),
+		WithGinCtxCallBack(func(c *gin.Context) {
+			_ = c.Keys["outer"]
+			_ = c.Request.URL.String()
+		}),
+		WithTimeoutCallBack(func(ev TimeoutEvent) {
+			_ = ev.Params.ByName("id")
+		}),
+	)
+	// "/race/x" finishes in time, "/race/xxxxx" times out.
+	serveConcurrently(router, 40, "/race/x", "/race/xxxxx")
+	orphans.Wait()
+}
+
+func TestRaceInline(t *testing.T) {
+	var orphans sync.WaitGroup
+	orphans.Add(40)
+	router := raceEngine(&orphans, WithInline(true))
+	serveConcurrently(router, 40, "/race/x", "/race/xxxxx")
+	orphans.Wait()
+}
+
+func TestRaceExtend(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(5*time.Millisecond),
+		WithExtendLimit(time.Second, 100),
+	))
+	router.GET("/extend", func(c *gin.Context) {
+		for i := 0; i < 100; i++ {
+			if err := Extend(c, time.Microsecond); err != nil {
+				assert.ErrorIs(t, err, ErrHandlerTimeout)
+			}
+			_, _ = Deadline(c)
+			_, _ = c.Request.Context().Deadline()
+			time.Sleep(100 * time.Microsecond)
+		}
+	})
+	serveConcurrently(router, 20, "/extend")
+}
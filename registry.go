@@ -0,0 +1,186 @@
+package timeout
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestState is the state of a request listed by a Registry.
+type RequestState string
+
+const (
+	// StateRunning means the handlers are running within their deadline.
+	StateRunning RequestState = "running"
+	// StateTimedOut means the timeout response was sent,
+	// or the client went away, but the handlers are still running.
+	StateTimedOut RequestState = "timed-out-orphan"
+	// StateWriting means the handlers finished and
+	// their response is being written to the client.
+	StateWriting RequestState = "writing"
+)
+
+// InFlightRequest describes a request inside Timeout, see Registry.
+type InFlightRequest struct {
+	ID        uint64       `json:"id"`
+	Method    string       `json:"method"`
+	Route     string       `json:"route"`
+	Path      string       `json:"path"`
+	ClientIP  string       `json:"client_ip"`
+	RequestID string       `json:"request_id,omitempty"`
+	Start     time.Time    `json:"start"`
+	Elapsed   Millis       `json:"elapsed_ms"`
+	Remaining Millis       `json:"remaining_ms"`
+	State     RequestState `json:"state"`
+}
+
+// Millis is a duration marshalled to JSON in milliseconds.
+type Millis time.Duration
+
+func (m Millis) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprint(time.Duration(m).Milliseconds())), nil
+}
+
+func (m *Millis) UnmarshalJSON(b []byte) error {
+	var ms int64
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return err
+	}
+	*m = Millis(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+func (m Millis) String() string {
+	return time.Duration(m).Round(time.Millisecond).String()
+}
+
+type inFlight struct {
+	ev    TimeoutEvent
+	st    *requestState
+	state RequestState
+}
+
+// Registry keeps track of the requests inside the Timeout middlewares
+// it is given to with WithRegistry, including the handlers that are
+// still running after the timeout. It can be shared by several middlewares.
+type Registry struct {
+	mu       sync.Mutex
+	requests map[uint64]*inFlight
+}
+
+func NewRegistry() *Registry {
+	return &Registry{requests: make(map[uint64]*inFlight)}
+}
+
+func (r *Registry) add(ev TimeoutEvent, st *requestState) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[st.id] = &inFlight{ev: ev, st: st, state: StateRunning}
+}
+
+// setState does nothing if the request was already removed.
+func (r *Registry) setState(st *requestState, state RequestState) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[st.id]; ok {
+		req.state = state
+	}
+}
+
+func (r *Registry) remove(st *requestState) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.requests, st.id)
+}
+
+// Requests returns the requests currently inside Timeout,
+// the oldest first.
+func (r *Registry) Requests() []InFlightRequest {
+	now := time.Now()
+	r.mu.Lock()
+	reqs := make([]InFlightRequest, 0, len(r.requests))
+	for id, req := range r.requests {
+		reqs = append(reqs, InFlightRequest{
+			ID:        id,
+			Method:    req.ev.Method,
+			Route:     req.ev.FullPath,
+			Path:      req.ev.Path,
+			ClientIP:  req.ev.ClientIP,
+			RequestID: req.ev.RequestID,
+			Start:     req.st.start,
+			Elapsed:   Millis(now.Sub(req.st.start)),
+			Remaining: Millis(max(req.st.getDeadline().Sub(now), 0)),
+			State:     req.state,
+		})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(reqs, func(i, j int) bool {
+		if !reqs[i].Start.Equal(reqs[j].Start) {
+			return reqs[i].Start.Before(reqs[j].Start)
+		}
+		return reqs[i].ID < reqs[j].ID
+	})
+	return reqs
+}
+
+// Handler lists the requests currently inside Timeout, the oldest first.
+// The list is a JSON array, or a text table with ?format=text.
+// ?sort=-age lists the most recent first.
+// ?state= only lists the requests in the given state.
+//
+// The list exposes paths and client IPs,
+// the handler should not be reachable from the outside.
+func (r *Registry) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqs := r.Requests()
+		if state := c.Query("state"); state != "" {
+			filtered := reqs[:0]
+			for _, req := range reqs {
+				if req.State == RequestState(state) {
+					filtered = append(filtered, req)
+				}
+			}
+			reqs = filtered
+		}
+		switch c.DefaultQuery("sort", "age") {
+		case "age":
+		case "-age":
+			for i, j := 0, len(reqs)-1; i < j; i, j = i+1, j-1 {
+				reqs[i], reqs[j] = reqs[j], reqs[i]
+			}
+		default:
+			c.String(http.StatusBadRequest, "unknown sort, expected age or -age")
+			return
+		}
+
+		if c.Query("format") != "text" {
+			c.JSON(http.StatusOK, reqs)
+			return
+		}
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		w := tabwriter.NewWriter(c.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATE\tMETHOD\tROUTE\tPATH\tCLIENT\tELAPSED\tREMAINING")
+		for _, req := range reqs {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", req.ID, req.State, req.Method,
+				req.Route, req.Path, req.ClientIP, req.Elapsed, req.Remaining)
+		}
+		_ = w.Flush()
+	}
+}
@@ -0,0 +1,77 @@
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type lateObserver struct {
+	NopObserver
+	done chan struct{}
+}
+
+func (o lateObserver) OnLateFinish(TimeoutEvent) { close(o.done) }
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	router := gin.New()
+	router.GET("/debug/requests", reg.Handler())
+	api := router.Group("/", Timeout(
+		WithTimeout(50*time.Millisecond),
+		WithRegistry(reg),
+		WithObserver(lateObserver{done: done}),
+	))
+	api.GET("/stuck/:id", func(c *gin.Context) {
+		close(started)
+		<-release
+	})
+
+	list := func(query string) []InFlightRequest {
+		code, _, body := Get("/debug/requests"+query, router, nil, nil)
+		assert.Equal(t, http.StatusOK, code)
+		var reqs []InFlightRequest
+		assert.NoError(t, json.Unmarshal(body, &reqs))
+		return reqs
+	}
+
+	result := make(chan int, 1)
+	go func() {
+		code, _, _ := Get("/stuck/1", router, map[string]string{"X-Request-ID": "abc"}, nil)
+		result <- code
+	}()
+	<-started
+	reqs := list("")
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, StateRunning, reqs[0].State)
+		assert.Equal(t, "/stuck/:id", reqs[0].Route)
+		assert.Equal(t, "abc", reqs[0].RequestID)
+		assert.Greater(t, reqs[0].Remaining, Millis(0))
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, <-result)
+	reqs = list("?state=" + string(StateTimedOut))
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, Millis(0), reqs[0].Remaining)
+	}
+	assert.Empty(t, list("?state="+string(StateRunning)))
+
+	code, contentType, body := Get("/debug/requests?format=text&sort=-age", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	assert.True(t, strings.HasPrefix(string(body), "ID"))
+	assert.Contains(t, string(body), "/stuck/1")
+
+	close(release)
+	<-done
+	assert.Empty(t, list(""))
+}
@@ -0,0 +1,71 @@
+package timeout
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Renderer writes the timeout response, with full control over
+// the status, the headers and the body, see WithRenderer.
+// The headers set with the options, e.g. WithTimeoutHeaders,
+// are already in w.Header() when Render is called.
+type Renderer interface {
+	Render(c *gin.Context, w http.ResponseWriter) error
+}
+
+// RendererFunc adapts a function to Renderer.
+type RendererFunc func(c *gin.Context, w http.ResponseWriter) error
+
+func (f RendererFunc) Render(c *gin.Context, w http.ResponseWriter) error {
+	return f(c, w)
+}
+
+// responseRenderer renders a Response, see WithResponse.
+type responseRenderer struct {
+	Response
+	// see WithEncoder
+	encoders map[string]Encoder
+}
+
+func (r responseRenderer) Render(c *gin.Context, w http.ResponseWriter) error {
+	contentType := r.GetContentType(c)
+	body, err := encodeBytes(r.GetContent(c), contentType, r.encoders)
+	h := w.Header()
+	if hr, ok := r.Response.(HeaderResponse); ok {
+		for k, vv := range hr.GetHeaders(c) {
+			h[http.CanonicalHeaderKey(k)] = slices.Clone(vv)
+		}
+	}
+	h.Set("Content-Type", contentType)
+	w.WriteHeader(r.GetCode(c))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// countingWriter counts the bytes written by a Renderer.
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += n
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
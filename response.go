@@ -9,16 +9,26 @@ import (
 var defaultResponse = &BaseResponse{
 	Code:        http.StatusServiceUnavailable,
 	Content:     `{"code": -1, "msg":"http: Handler timeout"}`,
-	ContentType: "text/plain; charset=utf-8",
+	ContentType: "application/json; charset=utf-8",
 }
 
+// Response describes the timeout response.
+// It is shared by all the requests, and must not be modified once
+// given to WithResponse. Use WithErrorHttpCode, WithDefaultMsg and
+// WithContentType to override parts of it for one middleware.
 type Response interface {
 	GetCode(c *gin.Context) int
 	GetContent(c *gin.Context) any
 	GetContentType(c *gin.Context) string
-	SetCode(int)
-	SetContent(any)
-	SetContentType(string)
+}
+
+// HeaderResponse is a Response that also sets headers on the timeout
+// response, e.g. a Retry-After computed from the load of the server.
+// They replace those set with WithTimeoutHeaders and WithRetryAfter,
+// but not Content-Type, which is given by GetContentType.
+type HeaderResponse interface {
+	Response
+	GetHeaders(c *gin.Context) http.Header
 }
 
 type BaseResponse struct {
@@ -39,14 +49,52 @@ func (r *BaseResponse) GetContentType(c *gin.Context) string {
 	return r.ContentType
 }
 
-func (r *BaseResponse) SetCode(code int) {
-	r.Code = code
+// responseOverride holds the options overriding parts of the Response.
+type responseOverride struct {
+	code        int
+	content     any
+	hasContent  bool
+	contentType string
+}
+
+// wrap returns resp with the overrides applied, resp is not modified.
+func (o responseOverride) wrap(resp Response) Response {
+	if o.code == 0 && !o.hasContent && o.contentType == "" {
+		return resp
+	}
+	return &overriddenResponse{Response: resp, override: o}
+}
+
+type overriddenResponse struct {
+	Response
+	override responseOverride
+}
+
+func (r *overriddenResponse) GetCode(c *gin.Context) int {
+	if r.override.code != 0 {
+		return r.override.code
+	}
+	return r.Response.GetCode(c)
+}
+
+func (r *overriddenResponse) GetContent(c *gin.Context) any {
+	if r.override.hasContent {
+		return r.override.content
+	}
+	return r.Response.GetContent(c)
 }
 
-func (r *BaseResponse) SetContent(content any) {
-	r.Content = content
+func (r *overriddenResponse) GetContentType(c *gin.Context) string {
+	if r.override.contentType != "" {
+		return r.override.contentType
+	}
+	return r.Response.GetContentType(c)
 }
 
-func (r *BaseResponse) SetContentType(contentType string) {
-	r.ContentType = contentType
+// GetHeaders implements HeaderResponse if the wrapped Response does.
+func (r *overriddenResponse) GetHeaders(c *gin.Context) http.Header {
+	if hr, ok := r.Response.(HeaderResponse); ok {
+		return hr.GetHeaders(c)
+	}
+	return nil
 }
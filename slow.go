@@ -0,0 +1,62 @@
+package timeout
+
+import (
+	"time"
+)
+
+// SlowObserver is an Observer that is also notified of the slow requests,
+// see WithSlowRatio. OnSlow is called on the Dispatcher, if any.
+type SlowObserver interface {
+	Observer
+	// OnSlow is called after OnFinish when the handlers took longer
+	// than ev.SlowThreshold, but finished before the timeout.
+	OnSlow(ev TimeoutEvent)
+}
+
+func (os observers) OnSlow(ev TimeoutEvent) {
+	for _, o := range os {
+		if so, ok := o.(SlowObserver); ok {
+			so.OnSlow(ev)
+		}
+	}
+}
+
+// onSlow calls OnSlow if o implements it.
+func onSlow(o Observer, ev TimeoutEvent) {
+	if so, ok := o.(SlowObserver); ok {
+		so.OnSlow(ev)
+	}
+}
+
+func (o *TimeoutOptions) slowThreshold() time.Duration {
+	return time.Duration(float64(o.Timeout) * o.SlowRatio)
+}
+
+// watchSlow captures the stack of the handler goroutine once the slow
+// threshold is crossed, see WithStackCapture. stop must be called once
+// the middleware stops waiting for the handlers.
+func (o *TimeoutOptions) watchSlow(st *requestState) (stop func() bool) {
+	if o.SlowRatio <= 0 || o.sampler == nil {
+		return func() bool { return false }
+	}
+	t := time.AfterFunc(o.slowThreshold(), func() {
+		if stack := o.captureStack(st); stack != "" {
+			st.mu.Lock()
+			st.slowStack = stack
+			st.mu.Unlock()
+		}
+	})
+	return t.Stop
+}
+
+// checkSlow notifies OnSlow if the handlers finished after the slow threshold.
+func (o *TimeoutOptions) checkSlow(ev TimeoutEvent, st *requestState) {
+	if o.SlowRatio <= 0 || ev.Elapsed <= o.slowThreshold() {
+		return
+	}
+	ev.SlowThreshold = o.slowThreshold()
+	st.mu.Lock()
+	ev.Stack = st.slowStack
+	st.mu.Unlock()
+	o.notify(onSlow, ev)
+}
@@ -0,0 +1,86 @@
+package timeout
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// labelRequestID is the pprof label the handler goroutine is tagged with,
+// so that its stack can be found in the goroutine profile.
+const labelRequestID = "gin_timeout_id"
+
+// requestSeq gives every request a unique id, see requestState.id
+var requestSeq atomic.Uint64
+
+// stackSampler decides which timeouts capture the handler's stack,
+// see WithStackCapture.
+type stackSampler struct {
+	rate     float64
+	interval time.Duration
+	last     atomic.Int64
+}
+
+func newStackSampler(rate float64, interval time.Duration) *stackSampler {
+	if rate <= 0 {
+		return nil
+	}
+	return &stackSampler{rate: rate, interval: interval}
+}
+
+// allow reports whether a stack can be captured now.
+func (s *stackSampler) allow() bool {
+	if s == nil {
+		return false
+	}
+	if s.rate < 1 && rand.Float64() >= s.rate {
+		return false
+	}
+	now := time.Now().UnixNano()
+	last := s.last.Load()
+	if now-last < int64(s.interval) {
+		return false
+	}
+	return s.last.CompareAndSwap(last, now)
+}
+
+// captureStack returns the stack of the handler goroutine of st
+// if the sampler allows it.
+func (o *TimeoutOptions) captureStack(st *requestState) string {
+	if !o.sampler.allow() {
+		return ""
+	}
+	return goroutineStack(labelRequestID, strconv.FormatUint(st.id, 10))
+}
+
+// goroutineStack finds the goroutine labelled with key=value
+// in the goroutine profile and returns its frames, one per line.
+// It returns "" if there is no such goroutine anymore.
+func goroutineStack(key, value string) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return ""
+	}
+	label := strconv.Quote(key) + ":" + strconv.Quote(value)
+	// the goroutines are grouped by stack and labels,
+	// the groups are separated by blank lines.
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		lines := strings.Split(record, "\n")
+		if len(lines) < 2 || !strings.HasPrefix(lines[1], "# labels: ") ||
+			!strings.Contains(lines[1], label) {
+			continue
+		}
+		frames := make([]string, 0, len(lines)-2)
+		for _, line := range lines[2:] {
+			if frame, ok := strings.CutPrefix(line, "#\t"); ok {
+				frames = append(frames, frame)
+			}
+		}
+		return strings.Join(frames, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package timeout
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TemplateRenderer renders the timeout response from an html/template,
+// or from the HTML templates loaded into a gin.Engine, see WithRenderer.
+// The template is executed with the following data, plus Data:
+//
+//	.Code        the status code
+//	.Timeout     the timeout, including the extensions, e.g. 3s
+//	.Method      the request method
+//	.Path        the request path
+//	.RequestID   the request id, see WithRequestIDHeader
+//	.SupportURL  SupportURL
+//
+// If rendering fails, Fallback is served as plain text
+// and the error is logged, see WithLogger.
+type TemplateRenderer struct {
+	// Code defaults to 503
+	Code int
+	// Template is executed if set, or its template called Name.
+	Template *template.Template
+	// Otherwise, the template Name loaded into Engine,
+	// e.g. with LoadHTMLGlob, is rendered.
+	Engine *gin.Engine
+	Name   string
+
+	SupportURL string
+	Data       map[string]any
+	// Fallback defaults to the status text
+	Fallback string
+}
+
+var _ Renderer = (*TemplateRenderer)(nil)
+
+func (r *TemplateRenderer) data(c *gin.Context, code int) map[string]any {
+	data := make(map[string]any, len(r.Data)+6)
+	for k, v := range r.Data {
+		data[k] = v
+	}
+	data["Code"] = code
+	data["Method"] = c.Request.Method
+	data["Path"] = c.Request.URL.Path
+	data["SupportURL"] = r.SupportURL
+	if st, ok := stateFrom(c.Request.Context()); ok {
+		data["Timeout"] = st.timeout + st.getExtended()
+		if st.requestIDHeader != "" {
+			data["RequestID"] = c.GetHeader(st.requestIDHeader)
+		}
+	}
+	return data
+}
+
+// execute renders the template into a buffer,
+// so that nothing is written if it fails.
+func (r *TemplateRenderer) execute(c *gin.Context, code int) ([]byte, error) {
+	data := r.data(c, code)
+	var w bufferWriter
+	switch {
+	case r.Template != nil && r.Name != "":
+		if err := r.Template.ExecuteTemplate(&w, r.Name, data); err != nil {
+			return nil, err
+		}
+	case r.Template != nil:
+		if err := r.Template.Execute(&w, data); err != nil {
+			return nil, err
+		}
+	case r.Engine != nil && r.Engine.HTMLRender != nil:
+		if err := r.Engine.HTMLRender.Instance(r.Name, data).Render(&w); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("gin-timeout: TemplateRenderer has no template")
+	}
+	return w.Bytes(), nil
+}
+
+func (r *TemplateRenderer) Render(c *gin.Context, w http.ResponseWriter) error {
+	code := r.Code
+	if code == 0 {
+		code = http.StatusServiceUnavailable
+	}
+	body, err := r.execute(c, code)
+	if err != nil {
+		fallback := r.Fallback
+		if fallback == "" {
+			fallback = http.StatusText(code)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		if _, werr := w.Write([]byte(fallback)); werr != nil {
+			return werr
+		}
+		return fmt.Errorf("gin-timeout: render template: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	_, err = w.Write(body)
+	return err
+}
@@ -2,10 +2,14 @@ package timeout
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,20 +17,56 @@ import (
 	"github.com/vearne/gin-timeout/buffpool"
 )
 
+// StatusClientClosedRequest is recorded as the response status when the
+// client goes away before the handler finishes, following nginx's convention.
+const StatusClientClosedRequest = 499
+
 var (
 	defaultOptions TimeoutOptions
 )
 
 func init() {
 	defaultOptions = TimeoutOptions{
-		CallBack:       nil,
-		GinCtxCallBack: nil,
-		Timeout:        3 * time.Second,
-		Response:       defaultResponse,
+		CallBack:         nil,
+		GinCtxCallBack:   nil,
+		TimeoutCallBack:  nil,
+		RequestIDHeader:  "X-Request-ID",
+		OnClientGone:     nil,
+		Observers:        nil,
+		ReturnWriteError: false,
+		Timeout:          3 * time.Second,
+		Response:         defaultResponse,
 	}
 }
 
 func Timeout(opts ...Option) gin.HandlerFunc {
+	// Options are applied once, every request gets a copy of the result.
+	cfg := &TimeoutWriter{TimeoutOptions: defaultOptions}
+
+	// Loop through each option
+	for _, opt := range opts {
+		// Call the option giving the instantiated
+		opt(cfg)
+	}
+
+	if cfg.Response == nil {
+		cfg.Response = defaultResponse
+	}
+	cfg.Response = cfg.override.wrap(cfg.Response)
+	cfg.render = cfg.Renderer
+	if cfg.render == nil {
+		cfg.render = responseRenderer{Response: cfg.Response, encoders: cfg.Encoders}
+	}
+	cfg.observer = cfg.buildObserver()
+	cfg.sampler = newStackSampler(cfg.StackSampleRate, cfg.StackInterval)
+	options := cfg.TimeoutOptions
+
+	if options.Inline {
+		return func(c *gin.Context) {
+			inline(c, &options)
+		}
+	}
+
 	return func(c *gin.Context) {
 		// **Notice**
 		// because gin use sync.pool to reuse context object.
@@ -34,109 +74,239 @@ func Timeout(opts ...Option) gin.HandlerFunc {
 		cp := *c //nolint: govet
 		c.Abort()
 
+		// Keys and Errors are cloned, so the handlers never modify c's
+		// while the middlewares registered before us may use them.
+		// They are propagated back to c once the handlers finish.
+		cp.Keys = maps.Clone(c.Keys)
+		cp.Errors = slices.Clone(c.Errors)
+		// gin reuses the backing array of Params for the next request,
+		// while the handlers may still be running after the timeout.
+		cp.Params = slices.Clone(c.Params)
+
 		// sync.Pool
 		buffer := buffpool.GetBuff()
+		// The handlers see the headers set by the middlewares registered
+		// before us, like they would without Timeout, e.g. Server-Timing.
 		tw := &TimeoutWriter{body: buffer, ResponseWriter: cp.Writer,
-			h: make(http.Header)}
-		tw.TimeoutOptions = defaultOptions
-
-		// Loop through each option
-		for _, opt := range opts {
-			// Call the option giving the instantiated
-			opt(tw)
-		}
-
-		if tw.Response == nil {
-			tw.Response = defaultResponse
-		}
+			h: cp.Writer.Header().Clone()}
+		tw.TimeoutOptions = options
 
 		cp.Writer = tw
 
-		// wrap the request context with a timeout
-		ctx, cancel := context.WithTimeout(cp.Request.Context(), tw.Timeout)
+		// wrap the request context with a timeout,
+		// ErrHandlerTimeout is used as the cause so that handlers can tell
+		// our deadline apart from other cancellations.
+		st := newRequestState(&tw.TimeoutOptions)
+		ctx, cancel := st.withContext(cp.Request.Context())
 		defer cancel()
+		stopSlow := tw.watchSlow(st)
+		defer stopSlow()
 
-		cp.Request = cp.Request.WithContext(ctx)
+		ev, req := tw.newEvent(c, cp.Request.WithContext(ctx), st)
+		cp.Request = req
+		tw.observe(Observer.OnStart, ev)
+		tw.Registry.add(ev, st)
 
 		// Channel capacity must be greater than 0.
 		// Otherwise, if the parent coroutine quit due to timeout,
 		// the child coroutine may never be able to quit.
 		finish := make(chan struct{}, 1)
 		panicChan := make(chan interface{}, 1)
-		go func() {
+		// closed once OnTimeout or OnClientGone is notified,
+		// the handlers wait for it before notifying OnLateFinish or OnPanic.
+		notified := make(chan struct{})
+		go func(ev TimeoutEvent) {
 			defer func() {
 				if p := recover(); p != nil {
 					err := fmt.Errorf("gin-timeout recover:%v, stack: \n :%v", p, string(debug.Stack()))
+					// nobody is waiting for the handlers anymore
+					if tw.finish(err) {
+						<-notified
+						tw.Registry.remove(st)
+						ev.TimedOut = true
+						ev.Panic = err
+						ev.Elapsed = time.Since(st.start)
+						tw.notify(Observer.OnPanic, ev)
+						return
+					}
 					panicChan <- err
 				}
 			}()
-			cp.Next()
+			tw.next(&cp, st)
+			if tw.finish(nil) {
+				<-notified
+				tw.Registry.remove(st)
+				ev.TimedOut = true
+				ev.Status, ev.Size = tw.Status(), tw.Size()
+				ev.Elapsed = time.Since(st.start)
+				ev.Extended = st.getExtended()
+				tw.notify(Observer.OnLateFinish, ev)
+				return
+			}
 			finish <- struct{}{}
-		}()
+		}(ev)
+
+		onPanic := func(p interface{}) {
+			tw.Registry.remove(st)
+			ev.Panic = p
+			ev.Elapsed = time.Since(st.start)
+			tw.observe(Observer.OnPanic, ev)
+			panic(p)
+		}
 
 		var err error
 		var n int
 		select {
 		case p := <-panicChan:
-			panic(p)
+			onPanic(p)
 
 		case <-ctx.Done():
-			tw.mu.Lock()
-			defer tw.mu.Unlock()
+			// closed even if a hook panics, not to leak the handlers' goroutine
+			defer close(notified)
+			var finished bool
+			var panicked interface{}
+			clientGone := func() bool {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
 
-			tw.timedOut.Store(true)
-			tw.ResponseWriter.WriteHeader(tw.Response.GetCode(&cp))
+				// The handlers may have returned right after the deadline,
+				// their response is dropped all the same.
+				finished, panicked = tw.finished, tw.panicked
+				tw.timedOut.Store(true)
+				if finished {
+					tw.Registry.remove(st)
+				} else {
+					tw.Registry.setState(st, StateTimedOut)
+				}
+				tw.err = context.Cause(ctx)
+
+				// The deadline did not expire, the client cancelled the request.
+				// There is nobody to send the timeout response to, so only the
+				// status is recorded for the middlewares registered before us.
+				if errors.Is(tw.err, context.Canceled) {
+					tw.code = StatusClientClosedRequest
+					tw.ResponseWriter.WriteHeader(tw.code)
+					return true
+				}
 
-			tw.ResponseWriter.Header().Set("Content-Type", tw.Response.GetContentType(&cp))
-			n, err = tw.ResponseWriter.Write(encodeBytes(tw.Response.GetContent(&cp)))
+				// The handlers may still be using cp,
+				// so the Response is given a copy of c instead.
+				rc := c.Copy()
+				rc.Request = req
+				n, err = writeTimeoutResponse(tw.ResponseWriter, rc, &tw.TimeoutOptions, st, tw.copied)
+				tw.code = tw.ResponseWriter.Status()
+				tw.size += n
+				return false
+			}()
+
+			// execute callback func, without holding the lock,
+			// so that they do not block the handlers.
+			ev.TimedOut = true
+			ev.Status, ev.Size = tw.code, n
+			ev.Elapsed = time.Since(st.start)
+			ev.Extended = st.getExtended()
 			if err != nil {
-				panic(err)
+				tw.logError("gin-timeout: write timeout response", ev, err)
 			}
-			tw.size += n
-			cp.Abort()
-
-			// execute callback func
-			if tw.CallBack != nil {
-				tw.CallBack(cp.Request)
+			if !clientGone && !finished {
+				ev.Stack = tw.captureStack(st)
 			}
-			if tw.GinCtxCallBack != nil {
-				tw.GinCtxCallBack(c)
+			if clientGone {
+				tw.notify(Observer.OnClientGone, ev)
+			} else {
+				tw.notify(Observer.OnTimeout, ev)
+			}
+			// the handlers will not report back, so it is done here.
+			if finished {
+				ev.Panic = panicked
+				if panicked != nil {
+					tw.notify(Observer.OnPanic, ev)
+				} else {
+					tw.notify(Observer.OnLateFinish, ev)
+				}
 			}
 			// If timeout happen, the buffer cannot be cleared actively,
 			// but wait for the GC to recycle.
+			return
 		case <-finish:
+			stopSlow()
+		}
+
+		tw.Registry.setState(st, StateWriting)
+
+		func() {
 			tw.mu.Lock()
 			defer tw.mu.Unlock()
+			// tw.h started as a copy of dst, the handlers may have removed some
 			dst := tw.ResponseWriter.Header()
-			for k, vv := range tw.Header() {
+			clear(dst)
+			for k, vv := range tw.h {
 				dst[k] = vv
 			}
+			st.setHeaders(dst, false)
+
+			// the handlers are done, so cp can be read without a lock.
+			c.Keys = cp.Keys
+			c.Errors = cp.Errors
 
 			if !tw.wroteHeader.Load() {
 				tw.code = c.Writer.Status()
 			}
 
+			// the body is truncated, sending it would corrupt the response
+			if tw.overflow {
+				dst.Del("Content-Length")
+				dst.Del("Content-Encoding")
+				dst.Set("Content-Type", "text/plain; charset=utf-8")
+				tw.code = http.StatusInternalServerError
+				buffer.Reset()
+				buffer.WriteString(http.StatusText(tw.code))
+				tw.size = buffer.Len()
+			}
+
 			tw.ResponseWriter.WriteHeader(tw.code)
 			if b := buffer.Bytes(); len(b) > 0 {
-				if _, err = tw.ResponseWriter.Write(b); err != nil {
-					panic(err)
-				}
+				_, err = tw.ResponseWriter.Write(b)
 			}
-			buffpool.PutBuff(buffer)
-		}
+		}()
+		buffpool.PutBuff(buffer)
+		tw.Registry.remove(st)
 
+		ev.Status, ev.Size = tw.code, tw.size
+		ev.Elapsed = time.Since(st.start)
+		ev.Extended = st.getExtended()
+		if tw.overflow {
+			tw.logOverflow(ev)
+		}
+		if err != nil {
+			tw.logError("gin-timeout: write response", ev, err)
+		}
+		tw.observe(Observer.OnFinish, ev)
+		tw.checkSlow(ev, st)
 	}
 }
 
-func encodeBytes(any interface{}) []byte {
-	var resp []byte
-	switch demsg := any.(type) {
-	case string:
-		resp = []byte(demsg)
-	case []byte:
-		resp = demsg
-	default:
-		resp, _ = json.Marshal(any)
+// writeTimeoutResponse writes the timeout response to w, with the
+// Renderer or the Response. c is only passed to them. The headers of
+// handlerHeader selected with WithCopyHeaders are copied, the handlers
+// must not modify it meanwhile.
+func writeTimeoutResponse(w gin.ResponseWriter, c *gin.Context, opts *TimeoutOptions, st *requestState,
+	handlerHeader http.Header) (int, error) {
+	h := w.Header()
+	for _, key := range opts.CopyHeaders {
+		if vv := handlerHeader.Values(key); len(vv) > 0 {
+			h[http.CanonicalHeaderKey(key)] = slices.Clone(vv)
+		}
+	}
+	for k, vv := range opts.TimeoutHeaders {
+		h[http.CanonicalHeaderKey(k)] = slices.Clone(vv)
 	}
-	return resp
+	if opts.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(opts.RetryAfter.Seconds())), 10))
+	}
+	st.setHeaders(h, true)
+
+	cw := &countingWriter{ResponseWriter: w}
+	err := opts.render.Render(c, cw)
+	return cw.n, err
 }
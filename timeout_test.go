@@ -1,31 +1,50 @@
 package timeout
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestEncodeBytes(t *testing.T) {
+	encode := func(v any) []byte {
+		b, err := encodeBytes(v, "application/json; charset=utf-8", nil)
+		assert.NoError(t, err)
+		return b
+	}
+
 	num := 1
-	assert.Equal(t, []byte("1"), encodeBytes(num))
+	assert.Equal(t, []byte("1"), encode(num))
 
 	var null map[string]string // nil
-	assert.Equal(t, []byte("null"), encodeBytes(null))
+	assert.Equal(t, []byte("null"), encode(null))
 
 	str := "abc"
-	assert.Equal(t, []byte(str), encodeBytes(str))
+	assert.Equal(t, []byte(str), encode(str))
 
 	bs := []byte("abc")
-	assert.Equal(t, bs, encodeBytes(bs))
+	assert.Equal(t, bs, encode(bs))
 
 	type Entry struct {
 		Name string `json:"name"`
@@ -33,8 +52,72 @@ func TestEncodeBytes(t *testing.T) {
 
 	out := `{"name":"gin-timeout"}`
 	entry := Entry{"gin-timeout"}
-	assert.Equal(t, []byte(out), encodeBytes(entry))
-	assert.Equal(t, []byte(out), encodeBytes(&entry))
+	assert.Equal(t, []byte(out), encode(entry))
+	assert.Equal(t, []byte(out), encode(&entry))
+
+	_, err := encodeBytes(make(chan int), "application/json", nil)
+	assert.Error(t, err)
+
+	b, err := encodeBytes(entry, "application/problem+json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(out), b)
+}
+
+func TestEncoders(t *testing.T) {
+	type Entry struct {
+		XMLName struct{} `xml:"entry" json:"-" yaml:"-"`
+		Name    string   `xml:"name" json:"name" yaml:"name"`
+	}
+	entry := Entry{Name: "gin-timeout"}
+	tests := []struct {
+		contentType string
+		content     any
+		body        string
+	}{
+		{"application/xml", entry, "<entry><name>gin-timeout</name></entry>"},
+		{"application/atom+xml", entry, "<entry><name>gin-timeout</name></entry>"},
+		{"application/x-yaml", entry, "name: gin-timeout\n"},
+		{"text/plain", entry, `{"name":"gin-timeout"}`},
+	}
+	// unless built with nomsgpack
+	if defaultEncoders["application/x-msgpack"] != nil {
+		tests = append(tests, struct {
+			contentType string
+			content     any
+			body        string
+		}{"application/x-msgpack", map[string]int{"a": 1}, "\x81\xa1a\x01"})
+	}
+	for _, tt := range tests {
+		b, err := encodeBytes(tt.content, tt.contentType, nil)
+		assert.NoError(t, err, tt.contentType)
+		assert.Equal(t, tt.body, string(b), tt.contentType)
+	}
+
+	pb, err := encodeBytes(wrapperspb.String("gin-timeout"), "application/x-protobuf", nil)
+	assert.NoError(t, err)
+	expected, _ := proto.Marshal(wrapperspb.String("gin-timeout"))
+	assert.Equal(t, expected, pb)
+
+	_, err = encodeBytes(entry, "application/x-protobuf", nil)
+	assert.ErrorContains(t, err, "is not a proto.Message")
+
+	upper := func(v any) render.Render {
+		return render.String{Format: "%s", Data: []any{strings.ToUpper(fmt.Sprint(v))}}
+	}
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithResponse(&BaseResponse{Code: http.StatusServiceUnavailable, Content: 42, ContentType: "text/x-upper"}),
+		WithEncoder("text/x-upper; charset=utf-8", upper),
+		WithDefaultMsg(struct{ Msg string }{"timeout"}),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	code, contentType, body := Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "text/x-upper", contentType)
+	assert.Equal(t, "{TIMEOUT}", string(body))
 }
 
 func testEngine() *gin.Engine {
@@ -203,7 +286,7 @@ func TestTimeout(t *testing.T) {
 	code, h, b = Get("/long", router, nil, nil)
 	assert.Equal(t, http.StatusRequestTimeout, code)
 	assert.Equal(t, `{"code": -1, "msg":"http: Handler timeout"}`, string(b))
-	assert.Equal(t, "text/plain; charset=utf-8", h)
+	assert.Equal(t, "application/json; charset=utf-8", h)
 
 	code, _, _ = Get("/b", router, nil, nil)
 	assert.Equal(t, http.StatusMovedPermanently, code)
@@ -269,3 +352,922 @@ func TestWriteSize(t *testing.T) {
 	assert.Equal(t, http.StatusOK, result.StatusCode)
 
 }
+
+func TestClientGone(t *testing.T) {
+	var timeoutCount, goneCount atomic.Int32
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(time.Second),
+		WithCallBack(func(r *http.Request) {
+			timeoutCount.Add(1)
+		}),
+		WithOnClientGone(func(r *http.Request) {
+			goneCount.Add(1)
+		}),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.JSON(http.StatusOK, gin.H{"hello": "long"})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	req := httptest.NewRequest("GET", "/long", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, StatusClientClosedRequest, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(0), timeoutCount.Load())
+	assert.Equal(t, int32(1), goneCount.Load())
+}
+
+func TestTimeoutHelpers(t *testing.T) {
+	type result struct {
+		remaining time.Duration
+		ok        bool
+		timedOut  bool
+		cause     error
+		err       error
+		parentErr error
+	}
+	results := make(chan result, 1)
+
+	router := gin.New()
+	router.Use(Timeout(WithTimeout(200 * time.Millisecond)))
+	router.GET("/long", func(c *gin.Context) {
+		var r result
+		r.remaining, r.ok = Remaining(c)
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		<-ctx.Done()
+		r.timedOut = IsTimedOut(ctx)
+		r.cause = context.Cause(ctx)
+		r.err = ctx.Err()
+		r.parentErr = c.Request.Context().Err()
+		results <- r
+	})
+
+	code, _, _ := Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	r := <-results
+	assert.True(t, r.ok)
+	assert.True(t, r.remaining > 0 && r.remaining <= 200*time.Millisecond)
+	assert.True(t, r.timedOut)
+	assert.ErrorIs(t, r.cause, ErrHandlerTimeout)
+	// like context.WithTimeout, for database/sql, net/http and gRPC
+	assert.Equal(t, context.DeadlineExceeded, r.err)
+	assert.Equal(t, context.DeadlineExceeded, r.parentErr)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	_, ok := Deadline(c)
+	assert.False(t, ok)
+	assert.False(t, IsTimedOut(c.Request.Context()))
+}
+
+func TestReturnWriteError(t *testing.T) {
+	errs := make(chan error, 2)
+	newRouter := func(opts ...Option) *gin.Engine {
+		router := gin.New()
+		router.Use(Timeout(append(opts, WithTimeout(100*time.Millisecond))...))
+		router.GET("/long", func(c *gin.Context) {
+			<-c.Request.Context().Done()
+			// let the middleware write the timeout response first
+			time.Sleep(20 * time.Millisecond)
+			_, err := c.Writer.WriteString("late")
+			errs <- err
+		})
+		return router
+	}
+
+	Get("/long", newRouter(), nil, nil)
+	assert.NoError(t, <-errs)
+
+	Get("/long", newRouter(WithReturnWriteError(true)), nil, nil)
+	err := <-errs
+	assert.ErrorIs(t, err, ErrHandlerTimeout)
+	assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+}
+
+func TestExtend(t *testing.T) {
+	errs := make(chan error, 3)
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(100*time.Millisecond),
+		WithExtendLimit(300*time.Millisecond, 2),
+	))
+	router.GET("/extend", func(c *gin.Context) {
+		before, _ := Deadline(c)
+		errs <- Extend(c, 200*time.Millisecond)
+		errs <- Extend(c, 200*time.Millisecond)
+		errs <- Extend(c, 200*time.Millisecond)
+		after, _ := Deadline(c)
+		ctxDeadline, _ := c.Request.Context().Deadline()
+		assert.Equal(t, 300*time.Millisecond, after.Sub(before))
+		assert.Equal(t, after, ctxDeadline)
+
+		time.Sleep(250 * time.Millisecond)
+		assert.NoError(t, c.Request.Context().Err())
+		c.String(http.StatusOK, "extended")
+	})
+
+	code, _, b := Get("/extend", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "extended", string(b))
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-errs)
+	assert.ErrorIs(t, <-errs, ErrExtendDenied)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	assert.ErrorIs(t, Extend(c, time.Second), ErrExtendDenied)
+}
+
+func TestExtendHeader(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(100*time.Millisecond),
+		WithExtendLimit(time.Second, 1),
+	))
+	router.GET("/extend", func(c *gin.Context) {
+		_ = Extend(c, 50*time.Millisecond)
+		c.String(http.StatusOK, "extended")
+	})
+
+	req := httptest.NewRequest("GET", "/extend", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "50", w.Header().Get(HeaderTimeoutExtended))
+}
+
+func TestInline(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(100*time.Millisecond),
+		WithInline(true),
+		WithErrorHttpCode(http.StatusGatewayTimeout),
+	))
+	router.GET("/short", func(c *gin.Context) {
+		c.String(http.StatusOK, "short")
+	})
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	router.GET("/committed", func(c *gin.Context) {
+		c.String(http.StatusOK, "committed")
+		<-c.Request.Context().Done()
+	})
+
+	code, _, b := Get("/short", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "short", string(b))
+
+	code, _, b = Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, `{"code": -1, "msg":"http: Handler timeout"}`, string(b))
+
+	code, _, b = Get("/committed", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "committed", string(b))
+
+	// nothing is buffered, and nothing written for a 204
+	obs := finishObserver{events: make(chan TimeoutEvent, 1)}
+	router = gin.New()
+	router.Use(Timeout(WithInline(true), WithObserver(obs)))
+	router.GET("/empty", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	code, _, _ = Get("/empty", router, nil, nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	ev := <-obs.events
+	assert.Equal(t, 0, ev.Size)
+	assert.False(t, ev.Buffered)
+}
+
+type finishObserver struct {
+	NopObserver
+	events chan TimeoutEvent
+}
+
+func (o finishObserver) OnFinish(ev TimeoutEvent) { o.events <- ev }
+
+func TestPropagateKeysAndErrors(t *testing.T) {
+	var keys map[string]any
+	var errs []string
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		keys = c.Keys
+		errs = c.Errors.Errors()
+	})
+	router.Use(Timeout(WithTimeout(time.Second)))
+	router.GET("/short", func(c *gin.Context) {
+		c.Set("user", "gin-timeout")
+		_ = c.Error(fmt.Errorf("something went wrong"))
+		c.String(http.StatusOK, "short")
+	})
+
+	code, _, _ := Get("/short", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]any{"user": "gin-timeout"}, keys)
+	assert.Equal(t, []string{"something went wrong"}, errs)
+}
+
+func TestTimeoutCallBack(t *testing.T) {
+	events := make(chan TimeoutEvent, 1)
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(100*time.Millisecond),
+		WithEventHeaders("User-Agent"),
+		WithTimeoutCallBack(func(ev TimeoutEvent) {
+			events <- ev
+		}),
+	))
+	router.GET("/user/:id", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	code, _, _ := Get("/user/42", router,
+		map[string]string{"X-Request-ID": "abc", "User-Agent": "test", "Cookie": "secret"}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	ev := <-events
+	assert.Equal(t, "GET", ev.Method)
+	assert.Equal(t, "/user/:id", ev.FullPath)
+	assert.Equal(t, "/user/42", ev.Path)
+	assert.Equal(t, "42", ev.Params.ByName("id"))
+	assert.Equal(t, "abc", ev.RequestID)
+	assert.Equal(t, http.Header{"User-Agent": {"test"}}, ev.Header)
+	assert.Equal(t, 100*time.Millisecond, ev.Timeout)
+	assert.GreaterOrEqual(t, ev.Elapsed, 100*time.Millisecond)
+	assert.False(t, ev.Start.IsZero())
+}
+
+type recordObserver struct {
+	NopObserver
+	events chan string
+	// OnTimeout sleeps for timeoutDelay before recording the event
+	timeoutDelay time.Duration
+}
+
+func (o *recordObserver) OnStart(ev TimeoutEvent)  { o.events <- "start " + ev.Path }
+func (o *recordObserver) OnFinish(ev TimeoutEvent) { o.events <- fmt.Sprint("finish ", ev.Status) }
+func (o *recordObserver) OnTimeout(ev TimeoutEvent) {
+	time.Sleep(o.timeoutDelay)
+	o.events <- fmt.Sprint("timeout ", ev.Status)
+}
+func (o *recordObserver) OnLateFinish(ev TimeoutEvent) { o.events <- fmt.Sprint("late ", ev.TimedOut) }
+func (o *recordObserver) OnPanic(ev TimeoutEvent) {
+	o.events <- fmt.Sprint("panic ", ev.TimedOut, " ", ev.Panic != nil)
+}
+
+func TestObserver(t *testing.T) {
+	obs := &recordObserver{events: make(chan string, 10)}
+	router := gin.New()
+	router.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.Use(Timeout(WithTimeout(100*time.Millisecond), WithObserver(obs)))
+	router.GET("/short", func(c *gin.Context) {
+		c.String(http.StatusCreated, "short")
+	})
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	router.GET("/late-panic", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		panic("boom")
+	})
+
+	Get("/short", router, nil, nil)
+	assert.Equal(t, "start /short", <-obs.events)
+	assert.Equal(t, "finish 201", <-obs.events)
+
+	Get("/long", router, nil, nil)
+	assert.Equal(t, "start /long", <-obs.events)
+	assert.Equal(t, "timeout 503", <-obs.events)
+	assert.Equal(t, "late true", <-obs.events)
+
+	Get("/panic", router, nil, nil)
+	assert.Equal(t, "start /panic", <-obs.events)
+	assert.Equal(t, "panic false true", <-obs.events)
+
+	Get("/late-panic", router, nil, nil)
+	assert.Equal(t, "start /late-panic", <-obs.events)
+	assert.Equal(t, "timeout 503", <-obs.events)
+	assert.Equal(t, "panic true true", <-obs.events)
+
+	// the handlers return right away, while OnTimeout is still running
+	obs = &recordObserver{events: make(chan string, 10), timeoutDelay: 50 * time.Millisecond}
+	router = gin.New()
+	router.Use(Timeout(WithTimeout(20*time.Millisecond), WithObserver(obs)))
+	router.GET("/prompt", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	Get("/prompt", router, nil, nil)
+	assert.Equal(t, "start /prompt", <-obs.events)
+	assert.Equal(t, "timeout 503", <-obs.events)
+	assert.Equal(t, "late true", <-obs.events)
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewJSONHandler(&lockedWriter{w: &buf, mu: &mu}, nil))
+	lateFinish := make(chan struct{})
+
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(50*time.Millisecond),
+		WithLogger(logger),
+		WithMaxBufferSize(4),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		defer close(lateFinish)
+		<-c.Request.Context().Done()
+	})
+	router.GET("/big", func(c *gin.Context) {
+		_, err := c.Writer.WriteString("too big")
+		assert.ErrorIs(t, err, ErrBufferOverflow)
+	})
+
+	Get("/long", router, map[string]string{"X-Request-ID": "abc"}, nil)
+	<-lateFinish
+	time.Sleep(10 * time.Millisecond)
+	code, contentType, body := Get("/big", router, nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	assert.Equal(t, "Internal Server Error", string(body))
+
+	mu.Lock()
+	defer mu.Unlock()
+	logs := buf.String()
+	assert.Contains(t, logs, `"level":"WARN","msg":"gin-timeout: handler timeout","route":"/long","method":"GET","status":503`)
+	assert.Contains(t, logs, `"request_id":"abc"`)
+	assert.Contains(t, logs, `"msg":"gin-timeout: handler finished after timeout"`)
+	assert.Contains(t, logs, `"msg":"gin-timeout: response buffer overflow","route":"/big"`)
+}
+
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (w *lockedWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(b)
+}
+
+func blockedHandler(c *gin.Context) {
+	<-c.Request.Context().Done()
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestStackCapture(t *testing.T) {
+	events := make(chan TimeoutEvent, 2)
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(20*time.Millisecond),
+		WithStackCapture(1, time.Hour),
+		WithTimeoutCallBack(func(ev TimeoutEvent) {
+			events <- ev
+		}),
+	))
+	router.GET("/", blockedHandler)
+
+	code, _, _ := Get("/", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	ev := <-events
+	assert.Contains(t, ev.Stack, "blockedHandler")
+
+	// rate limited
+	code, _, _ = Get("/", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	ev = <-events
+	assert.Empty(t, ev.Stack)
+}
+
+// goroutineLabels returns the pprof labels of the goroutines labelled with route.
+func goroutineLabels(route string) []string {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	var labels []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "# labels: ") && strings.Contains(line, `"route":"`+route+`"`) {
+			labels = append(labels, line)
+		}
+	}
+	return labels
+}
+
+func TestProfilerLabels(t *testing.T) {
+	labels := make(chan []string, 2)
+	router := gin.New()
+	router.Use(Timeout(WithTimeout(20 * time.Millisecond)))
+	router.GET("/labels/:id", func(c *gin.Context) {
+		labels <- goroutineLabels("/labels/:id")
+		<-c.Request.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		c.String(http.StatusOK, "late")
+		labels <- goroutineLabels("/labels/:id")
+	})
+
+	code, _, _ := Get("/labels/1", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	before, after := <-labels, <-labels
+	if assert.Len(t, before, 1) {
+		assert.Contains(t, before[0], `"method":"GET"`)
+		assert.Contains(t, before[0], `"timed_out":"false"`)
+	}
+	if assert.Len(t, after, 1) {
+		assert.Contains(t, after[0], `"timed_out":"true"`)
+	}
+
+	router.GET("/extend/:id", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_ = Extend(c, time.Second)
+		labels <- goroutineLabels("/extend/:id")
+	})
+	code, _, _ = Get("/extend/1", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	if l := <-labels; assert.Len(t, l, 1) {
+		assert.Contains(t, l[0], `"timed_out":"true"`)
+	}
+}
+
+func TestTimingHeaders(t *testing.T) {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Header("Server-Timing", "proxy;dur=1")
+	})
+	router.Use(Timeout(WithTimeout(50*time.Millisecond), WithTimingHeaders(true)))
+	router.GET("/short", func(c *gin.Context) {
+		c.Writer.Header().Add("Server-Timing", "db;dur=2")
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/short", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "50", w.Header().Get(HeaderTimeoutBudget))
+	assert.NotEqual(t, "0", w.Header().Get(HeaderTimeoutRemaining))
+	timing := w.Header().Values("Server-Timing")
+	if assert.Len(t, timing, 3) {
+		assert.Equal(t, []string{"proxy;dur=1", "db;dur=2"}, timing[:2])
+		assert.True(t, strings.HasPrefix(timing[2], "timeout-budget;dur=50, timeout-elapsed;dur="))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "50", w.Header().Get(HeaderTimeoutBudget))
+	assert.Equal(t, "0", w.Header().Get(HeaderTimeoutRemaining))
+	timing = w.Header().Values("Server-Timing")
+	if assert.Len(t, timing, 2) {
+		assert.Equal(t, "proxy;dur=1", timing[0])
+		assert.Contains(t, timing[1], `desc="timed out"`)
+	}
+
+	router = gin.New()
+	router.Use(Timeout(WithTimeout(50*time.Millisecond), WithTimingHeaders(true), WithInline(true)))
+	router.GET("/short", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/short", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "50", w.Header().Get(HeaderTimeoutBudget))
+	assert.Equal(t, []string{"timeout-budget;dur=50"}, w.Header().Values("Server-Timing"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "0", w.Header().Get(HeaderTimeoutRemaining))
+	timing = w.Header().Values("Server-Timing")
+	if assert.Len(t, timing, 1) {
+		assert.True(t, strings.HasPrefix(timing[0], "timeout-budget;dur=50, timeout-elapsed;dur="))
+	}
+}
+
+type slowObserver struct {
+	NopObserver
+	events chan TimeoutEvent
+}
+
+func (o *slowObserver) OnSlow(ev TimeoutEvent) { o.events <- ev }
+
+func slowHandler(c *gin.Context) {
+	time.Sleep(40 * time.Millisecond)
+	c.String(http.StatusOK, "slow")
+}
+
+func TestSlow(t *testing.T) {
+	obs := &slowObserver{events: make(chan TimeoutEvent, 1)}
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(100*time.Millisecond),
+		WithSlowRatio(0.2),
+		WithStackCapture(1, 0),
+		WithObserver(obs),
+	))
+	router.GET("/slow", slowHandler)
+	router.GET("/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "fast")
+	})
+
+	code, _, _ := Get("/fast", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _, _ = Get("/slow", router, nil, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	ev := <-obs.events
+	assert.Equal(t, "/slow", ev.Path)
+	assert.Equal(t, 20*time.Millisecond, ev.SlowThreshold)
+	assert.GreaterOrEqual(t, ev.Elapsed, 40*time.Millisecond)
+	assert.Contains(t, ev.Stack, "slowHandler")
+	assert.Empty(t, obs.events)
+}
+
+func TestNegotiatedResponse(t *testing.T) {
+	resp := NewNegotiatedResponse(http.StatusServiceUnavailable, "timeout <1s>")
+	router := gin.New()
+	router.Use(Timeout(WithTimeout(10*time.Millisecond), WithResponse(resp)))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json; charset=utf-8", `{"code":-1,"msg":"timeout \u003c1s\u003e"}`},
+		{"image/png", "application/json; charset=utf-8", `{"code":-1,"msg":"timeout \u003c1s\u003e"}`},
+		{"text/html;q=0.5, application/xml", "application/xml; charset=utf-8",
+			"<error><code>-1</code><msg>timeout &lt;1s&gt;</msg></error>"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8",
+			"<!DOCTYPE html><html><head><title>timeout &lt;1s&gt;</title></head><body><p>timeout &lt;1s&gt;</p></body></html>"},
+		{"text/*, text/html;q=0", "text/plain; charset=utf-8", "timeout <1s>"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/long", nil)
+		req.Header.Set("Accept", tt.accept)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"), tt.accept)
+
+		code, contentType, body := Get("/long", router, map[string]string{"Accept": tt.accept}, nil)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, tt.contentType, contentType, tt.accept)
+		assert.Equal(t, tt.body, string(body), tt.accept)
+	}
+
+	code, contentType, body := Get("/long", router, map[string]string{"Accept": "application/x-protobuf"}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "application/x-protobuf", contentType)
+	var pb structpb.Struct
+	if assert.NoError(t, proto.Unmarshal(body, &pb)) {
+		assert.Equal(t, map[string]any{"code": float64(-1), "msg": "timeout <1s>"}, pb.AsMap())
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		router := gin.New()
+		router.Use(Timeout(
+			WithTimeout(20*time.Millisecond),
+			WithInline(inline),
+			WithResponse(&ProblemResponse{
+				RetryAfter: 1500 * time.Millisecond,
+				Extensions: map[string]any{"status": "ignored", "code": "TIMEOUT"},
+			}),
+		))
+		router.GET("/user/:id", func(c *gin.Context) {
+			<-c.Request.Context().Done()
+		})
+
+		code, contentType, body := Get("/user/42", router, map[string]string{"X-Request-ID": "abc"}, nil)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, ContentTypeProblem, contentType)
+		assert.JSONEq(t, `{
+			"type": "about:blank",
+			"title": "Service Unavailable",
+			"status": 503,
+			"detail": "the request did not complete within 20ms",
+			"instance": "/user/42",
+			"timeout_ms": 20,
+			"request_id": "abc",
+			"retry_after": 2,
+			"code": "TIMEOUT"
+		}`, string(body), "inline %v", inline)
+	}
+}
+
+type retryResponse struct {
+	BaseResponse
+}
+
+func (r *retryResponse) GetHeaders(c *gin.Context) http.Header {
+	return http.Header{"Retry-After": {"7"}}
+}
+
+func TestTimeoutHeaders(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(20*time.Millisecond),
+		WithTimeoutHeaders(http.Header{"Cache-Control": {"no-store"}, "connection": {"close"}}),
+		WithRetryAfter(1500*time.Millisecond),
+		WithCopyHeaders("Access-Control-Allow-Origin", "X-Request-ID"),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		SetHeader(c, "X-Secret", "1")
+		SetHeader(c, "Access-Control-Allow-Origin", "*")
+		<-c.Request.Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "close", w.Header().Get("Connection"))
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("X-Secret"))
+	assert.Empty(t, w.Header().Values("X-Request-ID"))
+
+	router = gin.New()
+	router.Use(Timeout(
+		WithTimeout(20*time.Millisecond),
+		WithRetryAfter(time.Second),
+		WithResponse(&retryResponse{BaseResponse{Code: http.StatusServiceUnavailable, ContentType: "text/plain"}}),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, "7", w.Header().Get("Retry-After"))
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+}
+
+func TestResponseOverride(t *testing.T) {
+	shared := &BaseResponse{Code: http.StatusGatewayTimeout, Content: "shared", ContentType: "text/plain"}
+	long := func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	}
+
+	router := gin.New()
+	router.GET("/public", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithErrorHttpCode(http.StatusRequestTimeout),
+		WithResponse(shared),
+	), long)
+	router.GET("/internal", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithResponse(shared),
+		WithDefaultMsg(gin.H{"internal": true}),
+		WithContentType("application/json"),
+	), long)
+	router.GET("/default", Timeout(WithTimeout(10*time.Millisecond)), long)
+
+	code, contentType, body := Get("/public", router, nil, nil)
+	assert.Equal(t, http.StatusRequestTimeout, code)
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "shared", string(body))
+
+	code, contentType, body = Get("/internal", router, nil, nil)
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, `{"internal":true}`, string(body))
+
+	code, _, _ = Get("/default", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &BaseResponse{Code: http.StatusGatewayTimeout, Content: "shared", ContentType: "text/plain"}, shared)
+}
+
+func TestRenderer(t *testing.T) {
+	events := make(chan TimeoutEvent, 1)
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithRetryAfter(time.Second),
+		WithErrorHttpCode(http.StatusRequestTimeout),
+		WithRenderer(RendererFunc(func(c *gin.Context, w http.ResponseWriter) error {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			for _, chunk := range []string{"data: timeout\n\n", "data: bye\n\n"} {
+				if _, err := io.WriteString(w, chunk); err != nil {
+					return err
+				}
+				http.NewResponseController(w).Flush()
+			}
+			return nil
+		})),
+		WithTimeoutCallBack(func(ev TimeoutEvent) {
+			events <- ev
+		}),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "data: timeout\n\ndata: bye\n\n", w.Body.String())
+	assert.True(t, w.Flushed)
+	ev := <-events
+	assert.Equal(t, http.StatusGatewayTimeout, ev.Status)
+	assert.Equal(t, w.Body.Len(), ev.Size)
+}
+
+func TestResponseEncodeError(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	router := gin.New()
+	router.Use(Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithDefaultMsg(make(chan int)),
+		WithLogger(slog.New(slog.NewJSONHandler(&lockedWriter{w: &buf, mu: &mu}, nil))),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	code, _, body := Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Empty(t, body)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, buf.String(), `"msg":"gin-timeout: write timeout response"`)
+	assert.Contains(t, buf.String(), "unsupported type: chan int")
+}
+
+func TestI18nResponse(t *testing.T) {
+	catalog, err := LoadCatalog(fstest.MapFS{
+		"locales/en.json":      {Data: []byte(`{"timeout": "{{.Path}} timed out after {{.Timeout}}, {{.Support}}"}`)},
+		"locales/fr.json":      {Data: []byte(`{"timeout": "{{.Path}} a expiré après {{.Timeout}}"}`)},
+		"locales/zh_Hant.json": {Data: []byte(`{"timeout": "請求逾時"}`)},
+		"locales/de.json":      {Data: []byte(`{"other": "Zeitüberschreitung"}`)},
+	}, "locales/*.json")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		acceptLanguage string
+		language       string
+		body           string
+	}{
+		{"", "en", "/long timed out after 10ms, see https://example.com/status"},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "fr", "/long a expiré après 10ms"},
+		{"de;q=0.9, zh-Hant-TW;q=0.95", "zh-hant", "請求逾時"},
+		{"de, ja", "en", "/long timed out after 10ms, see https://example.com/status"},
+		{"es, *;q=0.5, fr;q=0.1", "en", "/long timed out after 10ms, see https://example.com/status"},
+	}
+	for _, inline := range []bool{false, true} {
+		router := gin.New()
+		router.Use(Timeout(
+			WithTimeout(10*time.Millisecond),
+			WithInline(inline),
+			WithResponse(&I18nResponse{
+				ContentType: "text/plain; charset=utf-8",
+				Catalog:     catalog,
+				MessageID:   "timeout",
+				Fallbacks:   []string{"en"},
+				Params:      map[string]any{"Support": "see https://example.com/status"},
+			}),
+		))
+		router.GET("/long", func(c *gin.Context) {
+			<-c.Request.Context().Done()
+		})
+
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/long", nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tt.language, w.Header().Get("Content-Language"), tt.acceptLanguage)
+			assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+			assert.Equal(t, tt.body, w.Body.String(), "%s, inline %v", tt.acceptLanguage, inline)
+		}
+	}
+
+	// no translation at all
+	resp := &I18nResponse{Catalog: NewCatalog(), MessageID: "timeout"}
+	router := gin.New()
+	router.Use(Timeout(WithTimeout(10*time.Millisecond), WithResponse(resp)))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	code, contentType, body := Get("/long", router, map[string]string{"Accept-Language": "en"}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "application/json; charset=utf-8", contentType)
+	assert.Equal(t, `{"code":-1,"msg":"timeout"}`, string(body))
+
+	// the path is escaped, and the message translated once
+	catalog = NewCatalog()
+	assert.NoError(t, catalog.Add("en", map[string]string{"timeout": "<p>{{.Path}} {{call .Count}}</p>"}))
+	var count atomic.Int32
+	resp = &I18nResponse{
+		ContentType: "text/html; charset=utf-8",
+		Catalog:     catalog,
+		MessageID:   "timeout",
+		Fallbacks:   []string{"en"},
+		Params:      map[string]any{"Count": func() int32 { return count.Add(1) }},
+	}
+	router = gin.New()
+	router.Use(Timeout(WithTimeout(10*time.Millisecond), WithResponse(resp)))
+	router.GET("/*path", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/%3Cscript%3E", nil))
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+	assert.Equal(t, "&lt;p&gt;/&lt;script&gt; 1&lt;/p&gt;", w.Body.String())
+	assert.Equal(t, int32(1), count.Load())
+
+	// a nil Catalog has no translation
+	router = gin.New()
+	router.Use(Timeout(WithTimeout(10*time.Millisecond), WithResponse(&I18nResponse{MessageID: "timeout"})))
+	router.GET("/long", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	code, _, body = Get("/long", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, `{"code":-1,"msg":"timeout"}`, string(body))
+
+	_, err = LoadCatalog(fstest.MapFS{"en.json": {Data: []byte(`{"timeout": "{{.Timeout"}`)}}, "*.json")
+	assert.Error(t, err)
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	long := func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	}
+	tmpl := template.Must(template.New("timeout").Parse(
+		`<p>{{.Path}} timed out after {{.Timeout}} ({{.RequestID}}), <a href="{{.SupportURL}}">{{.Team}}</a></p>`))
+
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.New("engine.tmpl").Parse(`<h1>{{.Code}}</h1>`)))
+	router.GET("/template", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithRenderer(&TemplateRenderer{
+			Template:   tmpl,
+			SupportURL: "https://example.com/help?a=1&b=2",
+			Data:       map[string]any{"Team": "<ops>"},
+		}),
+	), long)
+	router.GET("/engine", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithRenderer(&TemplateRenderer{Code: http.StatusGatewayTimeout, Engine: router, Name: "engine.tmpl"}),
+	), long)
+	router.GET("/inline", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithInline(true),
+		WithRenderer(&TemplateRenderer{Template: tmpl, SupportURL: "https://example.com/help"}),
+	), long)
+	router.GET("/fallback", Timeout(
+		WithTimeout(10*time.Millisecond),
+		WithRenderer(&TemplateRenderer{Template: tmpl, Name: "missing", Fallback: "timeout, retry later"}),
+	), long)
+
+	code, contentType, body := Get("/template", router, map[string]string{"X-Request-ID": "abc"}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	assert.Equal(t, `<p>/template timed out after 10ms (abc), <a href="https://example.com/help?a=1&amp;b=2">&lt;ops&gt;</a></p>`,
+		string(body))
+
+	code, contentType, body = Get("/inline", router, map[string]string{"X-Request-ID": "abc"}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, `<p>/inline timed out after 10ms (abc), <a href="https://example.com/help"></a></p>`, string(body))
+
+	code, contentType, body = Get("/engine", router, nil, nil)
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	assert.Equal(t, "<h1>504</h1>", string(body))
+
+	code, contentType, body = Get("/fallback", router, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	assert.Equal(t, "timeout, retry later", string(body))
+}
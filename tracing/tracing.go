@@ -0,0 +1,150 @@
+// Package tracing reports what the Timeout middleware does on the
+// OpenTelemetry span of the request.
+//
+//	engine.Use(otelgin.Middleware("myapp"))
+//	engine.Use(timeout.Timeout(timeout.WithObserver(tracing.NewObserver())))
+//
+// It is a module of its own, so that only its users depend on OpenTelemetry.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	timeout "github.com/vearne/gin-timeout"
+)
+
+const (
+	// ScopeName is the instrumentation scope of the handler spans.
+	ScopeName = "github.com/vearne/gin-timeout/tracing"
+	// HandlerSpanName is the name of the span covering the handlers.
+	HandlerSpanName = "gin-timeout.handler"
+)
+
+// Attribute keys set on the spans.
+const (
+	TimeoutKey    = attribute.Key("gin_timeout.timeout_ms")
+	ElapsedKey    = attribute.Key("gin_timeout.elapsed_ms")
+	TimedOutKey   = attribute.Key("gin_timeout.timed_out")
+	LateFinishKey = attribute.Key("gin_timeout.late_finish")
+	PanickedKey   = attribute.Key("gin_timeout.panicked")
+)
+
+// Observer is a timeout.ContextObserver adding events and attributes
+// to the span found in the request context, usually the server span.
+type Observer struct {
+	timeout.NopObserver
+	tracer trace.Tracer
+}
+
+// Option configures an Observer.
+type Option func(*Observer)
+
+// WithHandlerSpan starts a child span covering the handlers, it ends when
+// they return, even long after the timeout response was sent.
+// Unlike the server span, it can therefore tell whether they finished or panicked.
+func WithHandlerSpan(tp trace.TracerProvider) Option {
+	return func(o *Observer) {
+		o.tracer = tp.Tracer(ScopeName)
+	}
+}
+
+// NewObserver returns an Observer, by default it only annotates the server span.
+func NewObserver(opts ...Option) *Observer {
+	o := &Observer{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+var _ timeout.ContextObserver = (*Observer)(nil)
+
+type serverSpanKey struct{}
+
+// HandlerContext implements timeout.ContextObserver.
+func (o *Observer) HandlerContext(ctx context.Context, ev timeout.TimeoutEvent) context.Context {
+	if o.tracer == nil {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, serverSpanKey{}, trace.SpanFromContext(ctx))
+	ctx, _ = o.tracer.Start(ctx, HandlerSpanName,
+		trace.WithTimestamp(ev.Start),
+		trace.WithAttributes(TimeoutKey.Int64(ev.Timeout.Milliseconds())),
+	)
+	return ctx
+}
+
+// spans returns the server span, and the handler span if there is one.
+func spans(ev timeout.TimeoutEvent) (server, handler trace.Span) {
+	ctx := ev.Context()
+	if s, ok := ctx.Value(serverSpanKey{}).(trace.Span); ok {
+		return s, trace.SpanFromContext(ctx)
+	}
+	return trace.SpanFromContext(ctx), nil
+}
+
+// each calls f with the spans of ev.
+func each(ev timeout.TimeoutEvent, f func(trace.Span)) {
+	server, handler := spans(ev)
+	f(server)
+	if handler != nil {
+		f(handler)
+	}
+}
+
+func (o *Observer) OnFinish(ev timeout.TimeoutEvent) {
+	if _, handler := spans(ev); handler != nil {
+		handler.End()
+	}
+}
+
+func (o *Observer) OnTimeout(ev timeout.TimeoutEvent) {
+	each(ev, func(span trace.Span) {
+		span.AddEvent("timeout", trace.WithAttributes(
+			TimeoutKey.Int64((ev.Timeout+ev.Extended).Milliseconds()),
+			ElapsedKey.Int64(ev.Elapsed.Milliseconds()),
+		))
+		span.SetAttributes(TimedOutKey.Bool(true))
+		span.SetStatus(codes.Error, "gin-timeout: handler timeout")
+	})
+}
+
+func (o *Observer) OnClientGone(ev timeout.TimeoutEvent) {
+	each(ev, func(span trace.Span) {
+		span.AddEvent("client_gone", trace.WithAttributes(
+			ElapsedKey.Int64(ev.Elapsed.Milliseconds()),
+		))
+	})
+}
+
+// OnLateFinish records that the handlers kept running after the timeout.
+// The server span has usually ended by then, so only the handler span
+// gets the attributes.
+func (o *Observer) OnLateFinish(ev timeout.TimeoutEvent) {
+	each(ev, func(span trace.Span) {
+		span.SetAttributes(
+			LateFinishKey.Bool(true),
+			ElapsedKey.Int64(ev.Elapsed.Milliseconds()),
+		)
+	})
+	if _, handler := spans(ev); handler != nil {
+		handler.End()
+	}
+}
+
+func (o *Observer) OnPanic(ev timeout.TimeoutEvent) {
+	each(ev, func(span trace.Span) {
+		span.SetAttributes(PanickedKey.Bool(true))
+		if ev.TimedOut {
+			span.SetAttributes(LateFinishKey.Bool(true))
+		}
+		span.SetStatus(codes.Error, "gin-timeout: handler panic")
+	})
+	if _, handler := spans(ev); handler != nil {
+		handler.End()
+	}
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	timeout "github.com/vearne/gin-timeout"
+)
+
+func hasAttribute(attrs []attribute.KeyValue, kv attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == kv {
+			return true
+		}
+	}
+	return false
+}
+
+func TestObserver(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	lateFinish := make(chan struct{})
+
+	router := gin.New()
+	// stands for otelgin
+	router.Use(func(c *gin.Context) {
+		ctx, span := tp.Tracer("test").Start(c.Request.Context(), "server")
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	})
+	router.Use(timeout.Timeout(
+		timeout.WithTimeout(50*time.Millisecond),
+		timeout.WithObserver(NewObserver(WithHandlerSpan(tp))),
+	))
+	router.GET("/long", func(c *gin.Context) {
+		defer close(lateFinish)
+		assert.True(t, trace.SpanFromContext(c.Request.Context()).IsRecording())
+		<-c.Request.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	<-lateFinish
+	time.Sleep(10 * time.Millisecond)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	server, handler := spans[0], spans[1]
+	if server.Name() != "server" {
+		server, handler = handler, server
+	}
+
+	assert.Equal(t, HandlerSpanName, handler.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), handler.Parent().SpanID())
+
+	for _, span := range []sdktrace.ReadOnlySpan{server, handler} {
+		assert.Equal(t, codes.Error, span.Status().Code)
+		require.Len(t, span.Events(), 1)
+		assert.Equal(t, "timeout", span.Events()[0].Name)
+		assert.True(t, hasAttribute(span.Attributes(), TimedOutKey.Bool(true)))
+	}
+	// the server span ended before the handler returned
+	assert.False(t, hasAttribute(server.Attributes(), LateFinishKey.Bool(true)))
+	assert.True(t, hasAttribute(handler.Attributes(), LateFinishKey.Bool(true)))
+}
@@ -2,12 +2,19 @@ package timeout
 
 import (
 	"bytes"
-	"github.com/gin-gonic/gin"
+	"context"
+	"errors"
 	"net/http"
+	"slices"
 	"sync"
 	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
 )
 
+// ErrBufferOverflow is returned by writes exceeding WithMaxBufferSize.
+var ErrBufferOverflow = errors.New("gin-timeout: response buffer overflow")
+
 type TimeoutWriter struct {
 	gin.ResponseWriter
 	// header
@@ -19,24 +26,46 @@ type TimeoutWriter struct {
 	code        int
 	mu          sync.Mutex
 	timedOut    atomic.Bool
+	finished    bool        // the handlers returned, see finish
+	panicked    interface{} // what they panicked with, if they did
+	err         error       // returned by writes after timedOut, see WithReturnWriteError
 	wroteHeader atomic.Bool
 	size        int
+	overflow    bool            // a write exceeded MaxBufferSize
+	labels      context.Context // carries the pprof labels, see next
+	relabeled   bool
+	copied      http.Header // see snapshotHeaders
 }
 
 func (tw *TimeoutWriter) Write(b []byte) (int, error) {
 	tw.mu.Lock()
 	defer tw.mu.Unlock()
+	tw.snapshotHeaders()
 	if tw.timedOut.Load() {
+		tw.relabel()
+		if tw.ReturnWriteError {
+			return 0, tw.err
+		}
 		return 0, nil
 	}
+	if tw.MaxBufferSize > 0 && tw.body.Len()+len(b) > tw.MaxBufferSize {
+		tw.overflow = true
+		return 0, ErrBufferOverflow
+	}
 	tw.size += len(b)
 	return tw.body.Write(b)
 }
 
+func (tw *TimeoutWriter) WriteString(s string) (int, error) {
+	return tw.Write([]byte(s))
+}
+
 func (tw *TimeoutWriter) WriteHeader(code int) {
 	tw.mu.Lock()
 	defer tw.mu.Unlock()
+	tw.snapshotHeaders()
 	if tw.timedOut.Load() {
+		tw.relabel()
 		return
 	}
 	tw.writeHeader(code)
@@ -47,18 +76,92 @@ func (tw *TimeoutWriter) writeHeader(code int) {
 	tw.code = code
 }
 
+// finish marks the handlers as finished, p is what they panicked with,
+// and reports whether the middleware stopped waiting for them before.
+func (tw *TimeoutWriter) finish(p interface{}) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.finished = true
+	tw.panicked = p
+	return tw.timedOut.Load()
+}
+
 func (tw *TimeoutWriter) WriteHeaderNow() {}
 
+// Flush does nothing, the body is buffered until the handlers finish.
+func (tw *TimeoutWriter) Flush() {}
+
 func (tw *TimeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.snapshotHeaders()
 	return tw.h
 }
 
+// SetHeader sets a header of the response, like c.Header. Unlike c.Header,
+// a header selected with WithCopyHeaders is copied onto the timeout
+// response right away, even if the handler blocks afterwards.
+// An empty value deletes the header.
+func SetHeader(c *gin.Context, key, value string) {
+	tw, ok := c.Writer.(*TimeoutWriter)
+	if !ok {
+		// inline, or not handled by Timeout, the timeout response is
+		// written to c.Writer so it gets the header anyway.
+		c.Header(key, value)
+		return
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if value == "" {
+		tw.h.Del(key)
+	} else {
+		tw.h.Set(key, value)
+	}
+	tw.snapshotHeaders()
+}
+
+// snapshotHeaders copies the headers selected with WithCopyHeaders,
+// so that the timeout response can use them while the handlers modify tw.h.
+// It must be called with tw.mu held, by the handlers: the headers they set
+// with c.Header are copied the next time they call Header, WriteHeader
+// or Write, those set with SetHeader immediately.
+func (tw *TimeoutWriter) snapshotHeaders() {
+	if len(tw.CopyHeaders) == 0 || tw.timedOut.Load() {
+		return
+	}
+	if tw.copied == nil {
+		tw.copied = make(http.Header, len(tw.CopyHeaders))
+	}
+	for _, key := range tw.CopyHeaders {
+		key = http.CanonicalHeaderKey(key)
+		if vv := tw.h.Values(key); len(vv) > 0 {
+			tw.copied[key] = slices.Clone(vv)
+		} else {
+			delete(tw.copied, key)
+		}
+	}
+}
+
 func (tw *TimeoutWriter) Size() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
 	return tw.size
 }
 
+// Written reports whether the handlers wrote any body,
+// or the timeout response was written in their place.
+func (tw *TimeoutWriter) Written() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.size > 0 || tw.timedOut.Load()
+}
+
+// Status never reads the underlying writer after the timeout,
+// it is reused for the next request once the middleware returns.
 func (tw *TimeoutWriter) Status() int {
-	if tw.code == 0 || !tw.wroteHeader.Load() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.code == 0 {
 		return tw.ResponseWriter.Status()
 	}
 	return tw.code